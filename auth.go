@@ -1,6 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -8,7 +12,46 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtKey []byte
+// JWT の署名鍵（InitAuth で設定）
+// RS256 / EdDSA の秘密鍵、または鍵ファイル未設定時は JWT_SECRET による HS256
+var signingKey jwtSigningKey
+
+// JWT の検証鍵（kid → 鍵、InitAuth で設定）
+// 署名鍵に加えて、ローテーションで退役した鍵も期限切れまで検証に使う
+var verificationKeys map[string]jwtVerificationKey
+
+// リフレッシュトークンの有効期限（InitAuth で設定）
+var refreshTokenTTL time.Duration
+
+// ログイン試行制限の設定（InitAuth で設定）
+var loginThrottle LoginThrottlePolicy
+
+// パスワードの強度ポリシー（InitAuth で設定）
+var passwordPolicy PasswordPolicy
+
+// 個人アクセストークンの接頭辞（JWT と区別するため）
+const personalAccessTokenPrefix = "pat_"
+
+// 個人アクセストークンのスコープ
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// アクセストークンの有効期限
+const accessTokenTTL = 10 * time.Minute
+
+// パスワードリセットトークンの有効期限
+const passwordResetTTL = time.Hour
+
+// メールアドレス確認リンクの有効期限
+const emailVerificationTTL = 24 * time.Hour
+
+// メールアドレス変更の確認リンクの有効期限
+const emailChangeTTL = 24 * time.Hour
+
+// 二要素認証待ちトークンの有効期限
+const mfaPendingTTL = 5 * time.Minute
 
 func HashPassword(pw string) (string, error) {
 	b, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
@@ -20,36 +63,153 @@ func CheckPassword(hash, pw string) error {
 }
 
 type Claims struct {
-	UserID uint `json:"user_id"`
+	UserID    uint   `json:"user_id"`
+	Role      string `json:"role,omitempty"`
+	SessionID uint   `json:"sid,omitempty"`     // ログインセッション
+	Purpose   string `json:"purpose,omitempty"` // アクセストークンでは常に空
+	jwt.RegisteredClaims
+}
+
+// 用途を限定したトークン（メールアドレス確認など）の Claims
+// Purpose が一致しない限り検証に失敗するため、アクセストークンとしては使えない
+type PurposeClaims struct {
+	UserID  uint   `json:"user_id"`
+	Purpose string `json:"purpose"`
+	Email   string `json:"email,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(userID uint) (string, error) {
+// トークンの用途
+const (
+	purposeEmailVerify = "email_verify"
+	purposeMFAPending  = "mfa_pending" // パスワード認証済み・二要素認証待ち
+)
+
+func GenerateJWT(userID uint, role string, sessionID uint) (string, error) {
+	// jti は失効リスト（RevokedToken）のキーとして使う
+	jti, err := GenerateOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
 	claims := Claims{
-		UserID: userID,
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(10 * time.Minute)),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return signToken(claims)
+}
+
+// 現在の署名鍵で署名し、ヘッダーに kid を付ける
+func signToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	if signingKey.KID != "" {
+		token.Header["kid"] = signingKey.KID
+	}
+	return token.SignedString(signingKey.Key)
+}
+
+// kid から検証鍵を選ぶ（アルゴリズムが鍵と一致しない場合は拒否）
+func lookupVerificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	k, ok := verificationKeys[kid]
+	if !ok {
+		return nil, errors.New("unknown kid")
+	}
+	if t.Method.Alg() != k.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return k.Key, nil
 }
 
 // JWT 検証＆Claims 取得()
 func ParseJWT(tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, lookupVerificationKey)
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	claims := token.Claims.(*Claims)
+	if claims.Purpose != "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// 用途限定トークンの発行
+func GeneratePurposeToken(userID uint, purpose string, email string, ttl time.Duration) (string, error) {
+	claims := PurposeClaims{
+		UserID:  userID,
+		Purpose: purpose,
+		Email:   email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return signToken(claims)
+}
+
+// 用途限定トークンの検証（purpose が一致しない場合はエラー）
+func ParsePurposeToken(tokenStr string, purpose string) (*PurposeClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &PurposeClaims{}, lookupVerificationKey)
 	if err != nil || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
-	return token.Claims.(*Claims), nil
+	claims := token.Claims.(*PurposeClaims)
+	if claims.Purpose != purpose {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// ログイン失敗時のバックオフとロックの設定
+type LoginThrottlePolicy struct {
+	MaxFailuresPerEmail int           // この回数失敗するとアカウントをロック
+	MaxFailuresPerIP    int           // この回数失敗すると IP をロック
+	LockDuration        time.Duration // ロック時間（失敗回数のカウント期間も兼ねる）
+	BackoffBase         time.Duration // 1 回目の失敗後の待ち時間
+	BackoffMax          time.Duration // 待ち時間の上限
+}
+
+// 連続 failures 回失敗した後の待ち時間（失敗ごとに 2 倍）
+func (p LoginThrottlePolicy) Backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	d := p.BackoffBase
+	for i := 1; i < failures && d < p.BackoffMax; i++ {
+		d *= 2
+	}
+	if d > p.BackoffMax {
+		d = p.BackoffMax
+	}
+	return d
+}
+
+// 推測困難なランダム文字列を生成（リフレッシュトークン等に使用）
+func GenerateOpaqueToken(nBytes int) (string, error) {
+	b := make([]byte, nBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// トークンを SHA-256 でハッシュ化（DB にはハッシュのみ保存する）
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-//JWTの認証に使う秘密鍵の生成
+//JWTの認証に使う鍵（署名鍵・検証鍵）
 //パスワードハッシュ化
 //元のパスワードとハッシュ化したパスワードの確認
-//JWTに埋め込む情報を定義(ユーザー識別するものと有効期限を埋め込む)
+//JWTに埋め込む情報を定義(ユーザー識別するもの・権限と有効期限を埋め込む)
 //JWTの発行
 //JWTの検証とClamis取得
+//リフレッシュトークン用のランダム文字列生成とハッシュ化
+//メール確認などの用途限定トークンの発行と検証
@@ -1,5 +1,204 @@
 package main
 
-func InitAuth(config *Config) {
-	jwtKey = []byte(config.JWTSecret)
-}
\ No newline at end of file
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWT の署名鍵
+type jwtSigningKey struct {
+	KID    string
+	Method jwt.SigningMethod
+	Key    interface{} // *rsa.PrivateKey / ed25519.PrivateKey / []byte（HS256）
+}
+
+// JWT の検証鍵
+type jwtVerificationKey struct {
+	KID    string
+	Method jwt.SigningMethod
+	Key    interface{} // *rsa.PublicKey / ed25519.PublicKey / []byte（HS256）
+}
+
+// JWKS で公開する鍵（RFC 7517）
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`   // OKP
+}
+
+// JWKS で公開する鍵一覧（InitAuth で設定）
+var jwks []JWK
+
+func InitAuth(config *Config) error {
+	// 既定の JWT_SECRET は公開されているため、本番では誰でもトークンを偽造できてしまう
+	if config.Environment == "production" && config.JWTSecret == defaultJWTSecret {
+		return errors.New("JWT_SECRET must be set to a non-default value in production")
+	}
+
+	verificationKeys = map[string]jwtVerificationKey{}
+	jwks = nil
+
+	// iat を秒未満まで持たせ、一括失効（logout/all・権限変更）の直後に
+	// 同じ秒のうちに発行されたトークンまで拒否されないようにする
+	jwt.TimePrecision = time.Microsecond
+
+	// 鍵ファイル未設定時は従来どおり JWT_SECRET による HS256 で署名する
+	// HS256 のトークンは kid なしで発行されるため、kid なしの検証鍵として登録する
+	// 鍵ファイル設定後は JWT_VERIFY_HS256=true のときだけ HS256 の検証を続ける
+	if config.JWTSigningKeyFile == "" || config.JWTVerifyHS256 {
+		secret := []byte(config.JWTSecret)
+		verificationKeys[""] = jwtVerificationKey{Method: jwt.SigningMethodHS256, Key: secret}
+		signingKey = jwtSigningKey{Method: jwt.SigningMethodHS256, Key: secret}
+	}
+
+	if config.JWTSigningKeyFile != "" {
+		if config.JWTSigningKeyID == "" {
+			return errors.New("JWT_SIGNING_KEY_ID is required when JWT_SIGNING_KEY_FILE is set")
+		}
+		priv, err := loadPrivateKey(config.JWTSigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("load JWT signing key: %w", err)
+		}
+		method, err := signingMethodFor(priv.Public())
+		if err != nil {
+			return err
+		}
+		signingKey = jwtSigningKey{KID: config.JWTSigningKeyID, Method: method, Key: priv}
+		if err := addVerificationKey(config.JWTSigningKeyID, priv.Public()); err != nil {
+			return err
+		}
+	}
+
+	// 退役した鍵（kid=公開鍵ファイル）
+	kids := make([]string, 0, len(config.JWTVerificationKeyFiles))
+	for kid := range config.JWTVerificationKeyFiles {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	for _, kid := range kids {
+		pub, err := loadPublicKey(config.JWTVerificationKeyFiles[kid])
+		if err != nil {
+			return fmt.Errorf("load JWT verification key %q: %w", kid, err)
+		}
+		if err := addVerificationKey(kid, pub); err != nil {
+			return err
+		}
+	}
+
+	refreshTokenTTL = config.RefreshTokenTTL
+	loginThrottle = LoginThrottlePolicy{
+		MaxFailuresPerEmail: config.LoginMaxFailuresPerEmail,
+		MaxFailuresPerIP:    config.LoginMaxFailuresPerIP,
+		LockDuration:        config.LoginLockDuration,
+		BackoffBase:         config.LoginBackoffBase,
+		BackoffMax:          config.LoginBackoffMax,
+	}
+
+	passwordPolicy = PasswordPolicy{
+		MinLength:   config.PasswordMinLength,
+		RejectEmail: config.PasswordRejectEmail,
+	}
+	if config.PasswordCheckCommon {
+		common, err := loadCommonPasswords(config.PasswordCommonListFile)
+		if err != nil {
+			return fmt.Errorf("load common password list: %w", err)
+		}
+		passwordPolicy.CommonPasswords = common
+	}
+	return nil
+}
+
+// 公開鍵を検証鍵と JWKS に登録
+func addVerificationKey(kid string, pub crypto.PublicKey) error {
+	if _, ok := verificationKeys[kid]; ok {
+		return fmt.Errorf("duplicate JWT key id %q", kid)
+	}
+	method, err := signingMethodFor(pub)
+	if err != nil {
+		return err
+	}
+	verificationKeys[kid] = jwtVerificationKey{KID: kid, Method: method, Key: pub}
+
+	jwk := JWK{Kid: kid, Use: "sig", Alg: method.Alg()}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	}
+	jwks = append(jwks, jwk)
+	return nil
+}
+
+// 鍵の種類から署名アルゴリズムを決める
+func signingMethodFor(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T (RSA or Ed25519 only)", pub)
+	}
+}
+
+// PEM の秘密鍵を読み込む（PKCS#8 / PKCS#1）
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// PEM の公開鍵を読み込む（PKIX / PKCS#1）
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return block, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	_ "time/tzdata" // タイムゾーンのデータを同梱する（OS にない環境でも Asia/Tokyo などを読めるように）
+)
+
+// 予定・カレンダーのタイムゾーンの既定値
+const defaultTimeZone = "Asia/Tokyo"
+
+// 終了時刻のない面接は、重なりの判定でこの長さとみなす
+const defaultInterviewDuration = time.Hour
+
+// カレンダーで一度に取得できる期間
+const maxCalendarRange = 366 * 24 * time.Hour
+
+// 日付（YYYY-MM-DD）の形式
+const dateLayout = "2006-01-02"
+
+// 予定の紐づけ先（company_list_id / internship_id）が見つからない
+var errUnknownEventTarget = errors.New("linked company list or internship not found")
+
+// loadTimeZone は IANA のタイムゾーン名を読み込みます（空なら既定値）
+func loadTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		name = defaultTimeZone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// parseEventTime は予定の日時を読み取ります
+// RFC 3339 のほか、オフセットのない日時（2006-01-02T15:04[:05]）は loc の時刻として扱います
+func parseEventTime(name, value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp or a local time like 2006-01-02T15:04", name)
+}
+
+// parseCalendarBound は from / to を読み取ります（日付だけなら loc のその日の 0 時）
+func parseCalendarBound(name, value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("%s is required", name)
+	}
+	if t, err := time.ParseInLocation(dateLayout, value, loc); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be a date (YYYY-MM-DD) or an RFC 3339 timestamp", name)
+}
+
+// validateInternshipPeriod はインターンシップの実施期間を確認します
+func validateInternshipPeriod(startsOn, endsOn string) error {
+	if startsOn != "" {
+		if _, err := time.Parse(dateLayout, startsOn); err != nil {
+			return errors.New("starts_on must be a date (YYYY-MM-DD)")
+		}
+	}
+	if endsOn == "" {
+		return nil
+	}
+	if _, err := time.Parse(dateLayout, endsOn); err != nil {
+		return errors.New("ends_on must be a date (YYYY-MM-DD)")
+	}
+	if startsOn == "" {
+		return errors.New("ends_on requires starts_on")
+	}
+	if endsOn < startsOn {
+		return errors.New("ends_on must not be before starts_on")
+	}
+	return nil
+}
+
+// localize は予定の日時を予定のタイムゾーンの時刻にします（レスポンス用）
+func (e *Event) localize() {
+	loc, err := loadTimeZone(e.TimeZone)
+	if err != nil {
+		return
+	}
+	e.StartsAt = e.StartsAt.In(loc)
+	if e.EndsAt != nil {
+		t := e.EndsAt.In(loc)
+		e.EndsAt = &t
+	}
+	for i := range e.Reminders {
+		e.Reminders[i].RemindAt = e.Reminders[i].RemindAt.In(loc)
+	}
+}
+
+// 重なりの判定に使う終了時刻
+func (e Event) effectiveEnd() time.Time {
+	if e.EndsAt != nil {
+		return *e.EndsAt
+	}
+	if e.Type == EventTypeInterview {
+		return e.StartsAt.Add(defaultInterviewDuration)
+	}
+	return e.StartsAt
+}
+
+// reminderMinutes はリマインダーの分数を重複を除いて小さい順に並べます
+func reminderMinutes(minutes []int) []int {
+	seen := map[int]bool{}
+	var out []int
+	for _, m := range minutes {
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// カレンダーの 1 件（予定またはインターンシップの実施期間）
+type calendarItem struct {
+	Kind          string     `json:"kind"` // event / internship
+	ID            uint       `json:"id"`
+	Type          string     `json:"type,omitempty"`
+	Title         string     `json:"title"`
+	Company       string     `json:"company"`
+	StartsAt      time.Time  `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"` // インターンシップは最終日の翌日 0 時
+	AllDay        bool       `json:"all_day"`
+	TimeZone      string     `json:"time_zone"`
+	Location      string     `json:"location,omitempty"`
+	OnlineURL     string     `json:"online_url,omitempty"`
+	CompanyListID *uint      `json:"company_list_id,omitempty"`
+	InternshipID  *uint      `json:"internship_id,omitempty"`
+	Conflicts     []uint     `json:"conflicts,omitempty"` // 時間が重なっている面接の予定 ID
+}
+
+// interviewConflicts は時間が重なっている面接の組を、予定 ID → 重なっている予定 ID の一覧で返します
+func interviewConflicts(events []Event) map[uint][]uint {
+	var interviews []Event
+	for _, e := range events {
+		if e.Type == EventTypeInterview {
+			interviews = append(interviews, e)
+		}
+	}
+	sort.Slice(interviews, func(i, j int) bool { return interviews[i].StartsAt.Before(interviews[j].StartsAt) })
+	conflicts := map[uint][]uint{}
+	for i, a := range interviews {
+		end := a.effectiveEnd()
+		for _, b := range interviews[i+1:] {
+			if !b.StartsAt.Before(end) {
+				break
+			}
+			conflicts[a.ID] = append(conflicts[a.ID], b.ID)
+			conflicts[b.ID] = append(conflicts[b.ID], a.ID)
+		}
+	}
+	for id := range conflicts {
+		sort.Slice(conflicts[id], func(i, j int) bool { return conflicts[id][i] < conflicts[id][j] })
+	}
+	return conflicts
+}
+
+// internshipCalendarItem はインターンシップの実施期間を loc の終日の予定にします
+func internshipCalendarItem(in Internship, loc *time.Location) (calendarItem, bool) {
+	start, err := time.ParseInLocation(dateLayout, in.StartsOn, loc)
+	if err != nil {
+		return calendarItem{}, false
+	}
+	last := start
+	if in.EndsOn != "" {
+		if last, err = time.ParseInLocation(dateLayout, in.EndsOn, loc); err != nil {
+			return calendarItem{}, false
+		}
+	}
+	end := last.AddDate(0, 0, 1)
+	id := in.ID
+	return calendarItem{
+		Kind:         "internship",
+		ID:           in.ID,
+		Title:        in.Title,
+		Company:      in.Company,
+		StartsAt:     start,
+		EndsAt:       &end,
+		AllDay:       true,
+		TimeZone:     loc.String(),
+		InternshipID: &id,
+	}, true
+}
+
+// sortCalendarItems は開始時刻順（同じ時刻は終日のもの、種類、ID の順）に並べます
+func sortCalendarItems(items []calendarItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if !a.StartsAt.Equal(b.StartsAt) {
+			return a.StartsAt.Before(b.StartsAt)
+		}
+		if a.AllDay != b.AllDay {
+			return a.AllDay
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.ID < b.ID
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestInterviewConflicts(t *testing.T) {
+	at := func(hour, min int) time.Time { return time.Date(2026, 11, 2, hour, min, 0, 0, time.UTC) }
+	end := func(hour, min int) *time.Time { t := at(hour, min); return &t }
+
+	tests := []struct {
+		name   string
+		events []Event
+		want   map[uint][]uint
+	}{
+		{
+			name: "overlapping interviews",
+			events: []Event{
+				{Model: modelID(1), Type: EventTypeInterview, StartsAt: at(10, 0), EndsAt: end(11, 0)},
+				{Model: modelID(2), Type: EventTypeInterview, StartsAt: at(10, 30), EndsAt: end(11, 30)},
+			},
+			want: map[uint][]uint{1: {2}, 2: {1}},
+		},
+		{
+			name: "back to back is not a conflict",
+			events: []Event{
+				{Model: modelID(1), Type: EventTypeInterview, StartsAt: at(10, 0), EndsAt: end(11, 0)},
+				{Model: modelID(2), Type: EventTypeInterview, StartsAt: at(11, 0), EndsAt: end(12, 0)},
+			},
+			want: map[uint][]uint{},
+		},
+		{
+			name: "interview without an end lasts one hour",
+			events: []Event{
+				{Model: modelID(1), Type: EventTypeInterview, StartsAt: at(10, 0)},
+				{Model: modelID(2), Type: EventTypeInterview, StartsAt: at(10, 59), EndsAt: end(11, 30)},
+				{Model: modelID(3), Type: EventTypeInterview, StartsAt: at(11, 0), EndsAt: end(11, 30)},
+			},
+			want: map[uint][]uint{1: {2}, 2: {1, 3}, 3: {2}},
+		},
+		{
+			name: "other event types are ignored",
+			events: []Event{
+				{Model: modelID(1), Type: EventTypeInterview, StartsAt: at(10, 0), EndsAt: end(11, 0)},
+				{Model: modelID(2), Type: EventTypeWebTest, StartsAt: at(10, 0), EndsAt: end(11, 0)},
+			},
+			want: map[uint][]uint{},
+		},
+		{
+			name: "one long interview overlaps several",
+			events: []Event{
+				{Model: modelID(3), Type: EventTypeInterview, StartsAt: at(13, 0), EndsAt: end(13, 30)},
+				{Model: modelID(1), Type: EventTypeInterview, StartsAt: at(9, 0), EndsAt: end(15, 0)},
+				{Model: modelID(2), Type: EventTypeInterview, StartsAt: at(10, 0), EndsAt: end(10, 30)},
+			},
+			want: map[uint][]uint{1: {2, 3}, 2: {1}, 3: {1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := interviewConflicts(tt.events)
+			if len(got) != len(tt.want) {
+				t.Fatalf("conflicts = %v, want %v", got, tt.want)
+			}
+			for id, want := range tt.want {
+				if !equalUints(got[id], want) {
+					t.Errorf("conflicts[%d] = %v, want %v", id, got[id], want)
+				}
+			}
+		})
+	}
+}
+
+func TestListEventsBetween(t *testing.T) {
+	db := newTestDB(t)
+	u := newTestUser(t, db)
+	cl, err := createCompanyList(db, u.ID, "Acme", "", 0, "", StageEntry, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokyo, err := loadTimeZone("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEvent := func(title string, startsAt time.Time, endsAt *time.Time) {
+		t.Helper()
+		e := &Event{
+			UserID: u.ID, CompanyListID: &cl.ID, Type: EventTypeInterview, Title: title,
+			StartsAt: startsAt.UTC(), EndsAt: endsAt, TimeZone: "Asia/Tokyo",
+		}
+		if err := createEvent(db, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 08:00 JST は UTC では前日の 23:00
+	newEvent("morning", time.Date(2026, 11, 2, 8, 0, 0, 0, tokyo), nil)
+	newEvent("previous day", time.Date(2026, 11, 1, 20, 0, 0, 0, tokyo), nil)
+	spanEnd := time.Date(2026, 11, 2, 0, 30, 0, 0, tokyo).UTC()
+	newEvent("spans midnight", time.Date(2026, 11, 1, 23, 30, 0, 0, tokyo), &spanEnd)
+	newEvent("next day", time.Date(2026, 11, 3, 0, 0, 0, 0, tokyo), nil)
+
+	from := time.Date(2026, 11, 2, 0, 0, 0, 0, tokyo)
+	to := from.AddDate(0, 0, 1)
+	tests := []struct {
+		name     string
+		from, to time.Time
+	}{
+		{"request time zone", from, to},
+		{"UTC", from.UTC(), to.UTC()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := listEventsBetween(db, u.ID, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var titles []string
+			for _, e := range events {
+				titles = append(titles, e.Title)
+			}
+			want := []string{"spans midnight", "morning"}
+			if len(titles) != len(want) || titles[0] != want[0] || titles[1] != want[1] {
+				t.Errorf("events = %v, want %v", titles, want)
+			}
+		})
+	}
+}
+
+func TestUpdateEventKeepsSentReminders(t *testing.T) {
+	db := newTestDB(t)
+	u := newTestUser(t, db)
+	cl, err := createCompanyList(db, u.ID, "Acme", "", 0, "", StageEntry, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	startsAt := now.Add(30 * time.Minute)
+	e := &Event{
+		UserID: u.ID, CompanyListID: &cl.ID, Type: EventTypeInterview, Title: "Interview",
+		StartsAt: startsAt, TimeZone: "UTC", Reminders: newEventReminders(startsAt, []int{60, 10}),
+	}
+	if err := createEvent(db, e); err != nil {
+		t.Fatal(err)
+	}
+	claimed := func() []int {
+		t.Helper()
+		due, err := claimDueReminders(db, now, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var minutes []int
+		for _, r := range due {
+			minutes = append(minutes, r.MinutesBefore)
+		}
+		return minutes
+	}
+	if got := claimed(); len(got) != 1 || got[0] != 60 {
+		t.Fatalf("claimed = %v, want [60]", got)
+	}
+
+	steps := []struct {
+		name     string
+		startsAt time.Time
+		minutes  []int
+		want     []int
+	}{
+		{"title change", startsAt, []int{60, 10}, nil},
+		{"reminder added", startsAt, []int{60, 10, 45}, []int{45}},
+		{"reminder removed", startsAt, []int{60}, nil},
+		{"start moved", startsAt.Add(-5 * time.Minute), []int{60}, []int{60}},
+	}
+	for _, step := range steps {
+		update := *e
+		update.Title = step.name
+		update.StartsAt = step.startsAt
+		updated, err := updateEvent(db, e.ID, u.ID, &update, step.minutes)
+		if err != nil {
+			t.Fatalf("%s: updateEvent: %v", step.name, err)
+		}
+		if len(updated.Reminders) != len(step.minutes) {
+			t.Errorf("%s: reminders = %d, want %d", step.name, len(updated.Reminders), len(step.minutes))
+		}
+		if got := claimed(); len(got) != len(step.want) || (len(got) > 0 && got[0] != step.want[0]) {
+			t.Errorf("%s: claimed = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func modelID(id uint) (m gorm.Model) {
+	m.ID = id
+	return m
+}
+
+func equalUints(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
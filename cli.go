@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// runCommand はサーバーを起動せずに管理用のサブコマンドを実行します
+//
+//	./app promote-admin <email>  最初の管理者を作成（管理者が既にいる場合は失敗）
+func runCommand(db *gorm.DB, args []string) error {
+	switch args[0] {
+	case "promote-admin":
+		if len(args) != 2 {
+			return errors.New("usage: promote-admin <email>")
+		}
+		return promoteFirstAdmin(db, args[1])
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+// promoteFirstAdmin は既存ユーザーを最初の管理者に昇格します
+// 2 人目以降は管理者 API（PUT /admin/users/:id/role）で変更します
+func promoteFirstAdmin(db *gorm.DB, email string) error {
+	count, err := countUsersByRole(db, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("an admin already exists; use PUT /admin/users/:id/role instead")
+	}
+	u, err := getUserByEmail(db, email)
+	if err != nil {
+		return fmt.Errorf("user not found: %s", email)
+	}
+	if err := updateUserRole(db, u.ID, RoleAdmin); err != nil {
+		return err
+	}
+	// 既存トークンには旧権限が入っているため失効させ、再ログインで反映させる
+	if err := revokeAllUserTokens(db, u.ID); err != nil {
+		return err
+	}
+	if err := createAuditEvent(db, &AuditEvent{UserID: u.ID, Type: AuditRoleChange, Result: AuditResultSuccess, Detail: "role admin (promote-admin command)"}); err != nil {
+		return err
+	}
+	log.Printf("Promoted %s (id=%d) to admin", u.Email, u.ID)
+	return nil
+}
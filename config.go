@@ -3,17 +3,63 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// 退会時の投稿の扱い
+const (
+	PostPolicyAnonymize = "anonymize" // 投稿者を切り離して残す
+	PostPolicyDelete    = "delete"    // コメント・いいねごと削除する
+)
+
+// JWT_SECRET の既定値（開発用。公開されている値なので本番では使えない）
+const defaultJWTSecret = "your_dev_secret_key_which_is_long_enough"
+
 type Config struct {
 	DatabaseURL        string
 	Port               string
 	JWTSecret          string
+	// RS256 / EdDSA 署名（未設定なら JWTSecret による HS256）
+	JWTSigningKeyFile       string
+	JWTSigningKeyID         string
+	JWTVerificationKeyFiles map[string]string // kid → 公開鍵ファイル（退役した鍵）
+	JWTVerifyHS256          bool              // 鍵ファイルに切り替えた後も HS256 トークンを受け付けるか（移行期間だけ有効にする）
 	CORSAllowedOrigins []string
 	Environment        string
+	RefreshTokenTTL    time.Duration
+	FrontendURL        string
+	MailDriver         string
+	MailFrom           string
+	MailLogDir         string
+	SMTPHost           string
+	SMTPPort           string
+	SMTPUsername       string
+	SMTPPassword       string
+	// ログイン試行制限
+	LoginMaxFailuresPerEmail int
+	LoginMaxFailuresPerIP    int
+	LoginLockDuration        time.Duration
+	LoginBackoffBase         time.Duration
+	LoginBackoffMax          time.Duration
+	// パスワードの強度ポリシー
+	PasswordMinLength      int
+	PasswordRejectEmail    bool
+	PasswordCheckCommon    bool
+	PasswordCommonListFile string // 同梱の一覧に追加するファイル（1 行 1 件）
+	// 退会
+	AccountDeletionGracePeriod time.Duration // 0 なら即時削除
+	AccountDeletionPostPolicy  string        // anonymize / delete
+	// 個人データのエクスポート
+	ExportDir            string        // 非同期ジョブで生成した ZIP の保存先
+	ExportSyncMaxRecords int           // これを超えるレコード数は非同期ジョブにする
+	ExportTTL            time.Duration // 生成した ZIP をダウンロードできる期間
+	// 連絡先のフォローアップ
+	ContactFollowUpDays int // 最終連絡からこの日数が過ぎた連絡先を /contacts/due に出す
 }
 
 func LoadConfig() *Config {
@@ -25,10 +71,71 @@ func LoadConfig() *Config {
 	config := &Config{
 		DatabaseURL:  getEnv("DATABASE_URL", "sqlite://./example.db"),
 		Port:         getEnv("PORT", "8080"),
-		JWTSecret:    getEnv("JWT_SECRET", "your_dev_secret_key_which_is_long_enough"),
+		JWTSecret:    getEnv("JWT_SECRET", defaultJWTSecret),
 		Environment:  getEnv("ENVIRONMENT", "development"),
 	}
 
+	// JWT の署名鍵と検証鍵（JWT_VERIFICATION_KEYS は kid=ファイルパス のカンマ区切り）
+	config.JWTSigningKeyFile = getEnv("JWT_SIGNING_KEY_FILE", "")
+	config.JWTSigningKeyID = getEnv("JWT_SIGNING_KEY_ID", "")
+	config.JWTVerificationKeyFiles = map[string]string{}
+	for _, entry := range strings.Split(getEnv("JWT_VERIFICATION_KEYS", ""), ",") {
+		kid, path, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		config.JWTVerificationKeyFiles[strings.TrimSpace(kid)] = strings.TrimSpace(path)
+	}
+	config.JWTVerifyHS256 = getEnv("JWT_VERIFY_HS256", "false") == "true"
+
+	// リフレッシュトークンの有効期限（デフォルト 30 日）
+	config.RefreshTokenTTL = getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+	// メールに記載するリンクの URL（フロントエンド）
+	config.FrontendURL = strings.TrimRight(getEnv("FRONTEND_URL", "http://localhost:3000"), "/")
+
+	// メール送信設定（MAIL_DRIVER: log / smtp）
+	config.MailDriver = getEnv("MAIL_DRIVER", "log")
+	config.MailFrom = getEnv("MAIL_FROM", "no-reply@localhost")
+	config.MailLogDir = getEnv("MAIL_LOG_DIR", "")
+	config.SMTPHost = getEnv("SMTP_HOST", "localhost")
+	config.SMTPPort = getEnv("SMTP_PORT", "587")
+	config.SMTPUsername = getEnv("SMTP_USERNAME", "")
+	config.SMTPPassword = getEnv("SMTP_PASSWORD", "")
+
+	// ログイン失敗時のバックオフとロック
+	config.LoginMaxFailuresPerEmail = getEnvInt("LOGIN_MAX_FAILURES_PER_EMAIL", 5)
+	config.LoginMaxFailuresPerIP = getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 20)
+	config.LoginLockDuration = getEnvDuration("LOGIN_LOCK_DURATION", 15*time.Minute)
+	config.LoginBackoffBase = getEnvDuration("LOGIN_BACKOFF_BASE", time.Second)
+	config.LoginBackoffMax = getEnvDuration("LOGIN_BACKOFF_MAX", time.Minute)
+
+	// パスワードの強度ポリシー
+	config.PasswordMinLength = getEnvInt("PASSWORD_MIN_LENGTH", 8)
+	config.PasswordRejectEmail = getEnv("PASSWORD_REJECT_EMAIL", "true") == "true"
+	config.PasswordCheckCommon = getEnv("PASSWORD_CHECK_COMMON", "true") == "true"
+	config.PasswordCommonListFile = getEnv("PASSWORD_COMMON_LIST_FILE", "")
+
+	// 退会の猶予期間と投稿の扱い
+	config.AccountDeletionGracePeriod = getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 0)
+	config.AccountDeletionPostPolicy = getEnv("ACCOUNT_DELETION_POST_POLICY", PostPolicyAnonymize)
+	if config.AccountDeletionPostPolicy != PostPolicyAnonymize && config.AccountDeletionPostPolicy != PostPolicyDelete {
+		log.Printf("Invalid ACCOUNT_DELETION_POST_POLICY: %q, using %s", config.AccountDeletionPostPolicy, PostPolicyAnonymize)
+		config.AccountDeletionPostPolicy = PostPolicyAnonymize
+	}
+
+	// 個人データのエクスポート
+	config.ExportDir = getEnv("EXPORT_DIR", filepath.Join(os.TempDir(), "go-shop-exports"))
+	config.ExportSyncMaxRecords = getEnvInt("EXPORT_SYNC_MAX_RECORDS", 1000)
+	config.ExportTTL = getEnvDuration("EXPORT_TTL", 24*time.Hour)
+
+	// 連絡先のフォローアップ
+	config.ContactFollowUpDays = getEnvInt("CONTACT_FOLLOW_UP_DAYS", 14)
+	if config.ContactFollowUpDays < 1 {
+		log.Printf("Invalid CONTACT_FOLLOW_UP_DAYS: %d, using 14", config.ContactFollowUpDays)
+		config.ContactFollowUpDays = 14
+	}
+
 	// Parse CORS allowed origins
 	corsOrigins := getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3000")
 	config.CORSAllowedOrigins = strings.Split(corsOrigins, ",")
@@ -44,4 +151,30 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %q, using default %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s: %q, using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
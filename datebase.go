@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -48,11 +49,46 @@ func openGormDB(config *Config) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	// マイグレーション：User, CompanyList, Internship, Post, Comment, Like テーブルを自動作成／更新
-	if err := db.AutoMigrate(&User{}, &CompanyList{}, &Internship{}, &Post{}, &Comment{}, &Like{}); err != nil {
+	// verified_at 追加前から存在するユーザーは確認済みとして扱う
+	backfillVerifiedAt := db.Migrator().HasTable(&User{}) && !db.Migrator().HasColumn(&User{}, "VerifiedAt")
+	// stage 追加前の選考状況（自由入力）はステージに変換する
+	backfillCompanyStages := db.Migrator().HasTable(&CompanyList{}) && !db.Migrator().HasColumn(&CompanyList{}, "Stage")
+	backfillInternshipStages := db.Migrator().HasTable(&Internship{}) && !db.Migrator().HasColumn(&Internship{}, "Stage")
+	// rank 追加前の CompanyList には作成順に志望順位を振る
+	backfillCompanyRanks := db.Migrator().HasTable(&CompanyList{}) && !db.Migrator().HasColumn(&CompanyList{}, "Rank")
+
+	// マイグレーション：User, AuditEvent, RecoveryCode, Session, RefreshToken, RevokedToken, PasswordResetToken, EmailChangeRequest, LoginAttempt, PersonalAccessToken, ExportJob, SelectionStage, StageChange, Tag, Note, Contact, ContactInteraction, Event, EventReminder, CompanyList, Internship, Post, Comment, Like テーブルを自動作成／更新
+	if err := db.AutoMigrate(&User{}, &AuditEvent{}, &RecoveryCode{}, &Session{}, &RefreshToken{}, &RevokedToken{}, &PasswordResetToken{}, &EmailChangeRequest{}, &LoginAttempt{}, &PersonalAccessToken{}, &ExportJob{}, &SelectionStage{}, &StageChange{}, &Tag{}, &Note{}, &Contact{}, &ContactInteraction{}, &Event{}, &EventReminder{}, &CompanyList{}, &Internship{}, &Post{}, &Comment{}, &Like{}); err != nil {
 		return nil, err
 	}
 
+	if backfillVerifiedAt {
+		if err := db.Exec("UPDATE users SET verified_at = created_at WHERE verified_at IS NULL").Error; err != nil {
+			return nil, err
+		}
+	}
+	if backfillCompanyStages {
+		if err := backfillSelectionStages(db, &CompanyList{}); err != nil {
+			return nil, err
+		}
+	}
+	if backfillInternshipStages {
+		if err := backfillSelectionStages(db, &Internship{}); err != nil {
+			return nil, err
+		}
+	}
+	if backfillCompanyRanks {
+		var userIDs []uint
+		if err := db.Unscoped().Model(&CompanyList{}).Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, userID := range userIDs {
+			if err := rebalanceCompanyRanks(db, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	// SQLiteの場合のみ外部キー制約を有効化
 	if strings.HasPrefix(config.DatabaseURL, "sqlite://") {
 		if err := db.Exec("PRAGMA foreign_keys = ON;").Error; err != nil {
@@ -61,4 +97,122 @@ func openGormDB(config *Config) (*gorm.DB, error) {
 	}
 
 	return db, nil
-}
\ No newline at end of file
+}
+
+// backfillSelectionStages は既存レコードの選考状況の文字列をステージに変換します
+// 同じ文字列はまとめて更新し、変換できないものは未設定のまま残します
+func backfillSelectionStages(db *gorm.DB, model interface{}) error {
+	var selections []string
+	if err := db.Unscoped().Model(model).Where("selection <> ''").Distinct().Pluck("selection", &selections).Error; err != nil {
+		return err
+	}
+	for _, s := range selections {
+		stage := mapLegacySelection(s)
+		if stage == "" {
+			log.Printf("Could not map selection %q to a stage", s)
+			continue
+		}
+		if err := db.Unscoped().Model(model).Where("selection = ?", s).Update("stage", stage).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startTokenCleanup は期限切れの失効エントリ・リフレッシュトークンを定期的に削除します
+func startTokenCleanup(db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := deleteExpiredTokens(db, time.Now()); err != nil {
+				log.Printf("Failed to clean up expired tokens: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// startAccountPurge は退会の猶予期間を過ぎたユーザーのデータを定期的に削除します
+func startAccountPurge(db *gorm.DB, interval time.Duration, postPolicy string) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ids, err := listUsersDueForPurge(db, time.Now())
+			if err != nil {
+				log.Printf("Failed to list accounts due for purge: %v", err)
+			}
+			for _, id := range ids {
+				if err := purgeUser(db, id, postPolicy); err != nil {
+					log.Printf("Failed to purge account %d: %v", id, err)
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// startExportCleanup は期限切れのエクスポートファイルを定期的に削除します
+// 起動時には、前回の停止で中断されたジョブを失敗にします
+func startExportCleanup(db *gorm.DB, interval time.Duration, dir string, ttl time.Duration) {
+	if err := failInterruptedExportJobs(db); err != nil {
+		log.Printf("Failed to mark interrupted export jobs: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := cleanupExportFiles(db, dir, ttl, time.Now()); err != nil {
+				log.Printf("Failed to clean up export files: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// startEventReminders は送信時刻を過ぎた予定のリマインダーを定期的にメールで送ります
+// 予定がすでに始まっている場合は送らずに送信済みにします
+func startEventReminders(db *gorm.DB, mailer Mailer, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			reminders, err := claimDueReminders(db, now, 100)
+			if err != nil {
+				log.Printf("Failed to claim event reminders: %v", err)
+			}
+			for _, r := range reminders {
+				if err := sendEventReminder(db, mailer, r, now); err != nil {
+					log.Printf("Failed to send event reminder %d: %v", r.ID, err)
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// sendEventReminder は予定の持ち主にリマインダーのメールを送ります
+func sendEventReminder(db *gorm.DB, mailer Mailer, r EventReminder, now time.Time) error {
+	var e Event
+	if err := db.First(&e, r.EventID).Error; err != nil {
+		return err
+	}
+	if !e.StartsAt.After(now) {
+		return nil
+	}
+	var u User
+	if err := db.First(&u, e.UserID).Error; err != nil {
+		return err
+	}
+	e.localize()
+	body := fmt.Sprintf("予定「%s」が %s に始まります。\n", e.Title, e.StartsAt.Format("2006-01-02 15:04 MST"))
+	if e.Location != "" {
+		body += fmt.Sprintf("場所: %s\n", e.Location)
+	}
+	if e.OnlineURL != "" {
+		body += fmt.Sprintf("URL: %s\n", e.OnlineURL)
+	}
+	return mailer.Send(u.Email, "予定のリマインダー: "+e.Title, body)
+}
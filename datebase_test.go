@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// newTestDB はテストごとにマイグレーション済みのインメモリ SQLite を開きます
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := openGormDB(&Config{DatabaseURL: "sqlite://file:" + name + "?mode=memory&cache=shared"})
+	if err != nil {
+		t.Fatalf("openGormDB: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("db.DB: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// newTestUser はテスト用のユーザーを作成します
+func newTestUser(t *testing.T, db *gorm.DB) *User {
+	t.Helper()
+	var count int64
+	db.Model(&User{}).Unscoped().Count(&count)
+	u := &User{Email: fmt.Sprintf("user%d@example.com", count+1), Password: "x"}
+	if err := db.Create(u).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return u
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// エクスポートの形式のバージョン（ファイル構成や列を変えたら上げる）
+const exportSchemaVersion = 8
+
+// エクスポートジョブの状態
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// exportManifest は ZIP に含める manifest.json の内容です
+type exportManifest struct {
+	SchemaVersion int                  `json:"schema_version"`
+	GeneratedAt   time.Time            `json:"generated_at"`
+	UserID        uint                 `json:"user_id"`
+	Email         string               `json:"email"`
+	Files         []exportManifestFile `json:"files"`
+}
+
+type exportManifestFile struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+}
+
+// exportDataset は 1 種類のデータを JSON と CSV の 2 ファイルで書き出すための定義です
+type exportDataset struct {
+	Name    string
+	Records interface{}
+	Header  []string
+	Rows    [][]string
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatUint(n uint) string {
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+// formatOptionalUint は nil を空文字にします
+func formatOptionalUint(n *uint) string {
+	if n == nil {
+		return ""
+	}
+	return formatUint(*n)
+}
+
+// formatTagNames は CSV 用にタグ名を ; 区切りでつなげます
+func formatTagNames(tags []Tag) string {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ";")
+}
+
+// loadExportDatasets はユーザーが所有するデータをすべて読み込みます
+func loadExportDatasets(db *gorm.DB, userID uint) ([]exportDataset, error) {
+	companies, err := listCompanyLists(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	internships, err := listInternships(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := listPostsByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := listCommentsByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	likes, err := listLikesByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	stageChanges, err := listStageChangesByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := listTags(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := listNotesByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := listContactsByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	interactions, err := listContactInteractionsByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := listEventsByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	companyRows := make([][]string, 0, len(companies))
+	for _, l := range companies {
+		companyRows = append(companyRows, []string{
+			formatUint(l.ID), l.Company, l.Occupation, strconv.Itoa(l.Member), l.Selection, l.Stage,
+			strconv.FormatBool(l.Intern), strconv.FormatInt(l.Rank, 10), formatTagNames(l.Tags), formatTime(l.CreatedAt), formatTime(l.UpdatedAt),
+		})
+	}
+	internshipRows := make([][]string, 0, len(internships))
+	for _, in := range internships {
+		internshipRows = append(internshipRows, []string{
+			formatUint(in.ID), in.Title, in.Company, strconv.Itoa(in.Dailystart), strconv.Itoa(in.Dailyfinish), in.StartsOn, in.EndsOn,
+			in.Content, in.Selection, in.Stage, strconv.FormatBool(in.Joined), formatTagNames(in.Tags), formatTime(in.CreatedAt), formatTime(in.UpdatedAt),
+		})
+	}
+	postRows := make([][]string, 0, len(posts))
+	for _, p := range posts {
+		postRows = append(postRows, []string{
+			formatUint(p.ID), p.Title, p.Content, p.DisplayName,
+			strconv.Itoa(p.LikeCount), strconv.Itoa(p.CommentCount), formatTime(p.CreatedAt),
+		})
+	}
+	commentRows := make([][]string, 0, len(comments))
+	for _, cm := range comments {
+		commentRows = append(commentRows, []string{
+			formatUint(cm.ID), formatUint(cm.PostID), cm.Content, cm.DisplayName, formatTime(cm.CreatedAt),
+		})
+	}
+	stageChangeRows := make([][]string, 0, len(stageChanges))
+	for _, sc := range stageChanges {
+		stageChangeRows = append(stageChangeRows, []string{
+			formatUint(sc.ID), formatUint(sc.CompanyListID), sc.FromStage, sc.ToStage, sc.Selection,
+			formatTime(sc.ChangedAt), sc.Note,
+		})
+	}
+	tagRows := make([][]string, 0, len(tags))
+	for _, t := range tags {
+		tagRows = append(tagRows, []string{formatUint(t.ID), t.Name, t.Color, formatTime(t.CreatedAt)})
+	}
+	noteRows := make([][]string, 0, len(notes))
+	for _, n := range notes {
+		noteRows = append(noteRows, []string{
+			formatUint(n.ID), formatUint(n.CompanyListID), n.Type, n.Title, n.Body, n.Stage,
+			formatTime(n.CreatedAt), formatTime(n.UpdatedAt),
+		})
+	}
+	contactRows := make([][]string, 0, len(contacts))
+	for _, ct := range contacts {
+		lastContacted := ""
+		if ct.LastContactedAt != nil {
+			lastContacted = formatTime(*ct.LastContactedAt)
+		}
+		contactRows = append(contactRows, []string{
+			formatUint(ct.ID), formatUint(ct.CompanyListID), ct.Name, ct.Role, ct.Email, ct.Phone,
+			lastContacted, ct.Notes, formatTime(ct.CreatedAt),
+		})
+	}
+	interactionRows := make([][]string, 0, len(interactions))
+	for _, in := range interactions {
+		interactionRows = append(interactionRows, []string{
+			formatUint(in.ID), formatUint(in.ContactID), in.Type, formatTime(in.OccurredAt), in.Summary,
+		})
+	}
+	eventRows := make([][]string, 0, len(events))
+	for _, ev := range events {
+		endsAt := ""
+		if ev.EndsAt != nil {
+			endsAt = formatTime(*ev.EndsAt)
+		}
+		reminders := make([]string, 0, len(ev.Reminders))
+		for _, r := range ev.Reminders {
+			reminders = append(reminders, strconv.Itoa(r.MinutesBefore))
+		}
+		eventRows = append(eventRows, []string{
+			formatUint(ev.ID), formatOptionalUint(ev.CompanyListID), formatOptionalUint(ev.InternshipID), ev.Type, ev.Title,
+			formatTime(ev.StartsAt), endsAt, ev.TimeZone, ev.Location, ev.OnlineURL, strings.Join(reminders, ";"),
+		})
+	}
+	likeRows := make([][]string, 0, len(likes))
+	for _, lk := range likes {
+		likeRows = append(likeRows, []string{formatUint(lk.ID), formatUint(lk.PostID), formatTime(lk.CreatedAt)})
+	}
+
+	return []exportDataset{
+		{
+			Name:    "company_lists",
+			Records: companies,
+			Header:  []string{"id", "company", "occupation", "member", "selection", "stage", "intern", "rank", "tags", "created_at", "updated_at"},
+			Rows:    companyRows,
+		},
+		{
+			Name:    "tags",
+			Records: tags,
+			Header:  []string{"id", "name", "color", "created_at"},
+			Rows:    tagRows,
+		},
+		{
+			Name:    "notes",
+			Records: notes,
+			Header:  []string{"id", "company_list_id", "type", "title", "body", "stage", "created_at", "updated_at"},
+			Rows:    noteRows,
+		},
+		{
+			Name:    "contacts",
+			Records: contacts,
+			Header:  []string{"id", "company_list_id", "name", "role", "email", "phone", "last_contacted_at", "notes", "created_at"},
+			Rows:    contactRows,
+		},
+		{
+			Name:    "contact_interactions",
+			Records: interactions,
+			Header:  []string{"id", "contact_id", "type", "occurred_at", "summary"},
+			Rows:    interactionRows,
+		},
+		{
+			Name:    "events",
+			Records: events,
+			Header:  []string{"id", "company_list_id", "internship_id", "type", "title", "starts_at", "ends_at", "time_zone", "location", "online_url", "reminders"},
+			Rows:    eventRows,
+		},
+		{
+			Name:    "stage_changes",
+			Records: stageChanges,
+			Header:  []string{"id", "company_list_id", "from_stage", "to_stage", "selection", "changed_at", "note"},
+			Rows:    stageChangeRows,
+		},
+		{
+			Name:    "internships",
+			Records: internships,
+			Header:  []string{"id", "title", "company", "dailystart", "dailyfinish", "starts_on", "ends_on", "content", "selection", "stage", "joined", "tags", "created_at", "updated_at"},
+			Rows:    internshipRows,
+		},
+		{
+			Name:    "posts",
+			Records: posts,
+			Header:  []string{"id", "title", "content", "display_name", "like_count", "comment_count", "created_at"},
+			Rows:    postRows,
+		},
+		{
+			Name:    "comments",
+			Records: comments,
+			Header:  []string{"id", "post_id", "content", "display_name", "created_at"},
+			Rows:    commentRows,
+		},
+		{
+			Name:    "likes",
+			Records: likes,
+			Header:  []string{"id", "post_id", "created_at"},
+			Rows:    likeRows,
+		},
+	}, nil
+}
+
+// writeUserExport はユーザーのデータを ZIP（manifest.json と各データの JSON / CSV）として書き出します
+func writeUserExport(w io.Writer, db *gorm.DB, u *User) error {
+	datasets, err := loadExportDatasets(db, u.ID)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	manifest := exportManifest{
+		SchemaVersion: exportSchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		UserID:        u.ID,
+		Email:         u.Email,
+	}
+	for _, ds := range datasets {
+		f, err := zw.Create(ds.Name + ".json")
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(ds.Records); err != nil {
+			return err
+		}
+
+		f, err = zw.Create(ds.Name + ".csv")
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(f)
+		if err := cw.Write(ds.Header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(ds.Rows); err != nil {
+			return err
+		}
+
+		manifest.Files = append(manifest.Files,
+			exportManifestFile{Name: ds.Name + ".json", Records: len(ds.Rows)},
+			exportManifestFile{Name: ds.Name + ".csv", Records: len(ds.Rows)},
+		)
+	}
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// exportFileName はダウンロード時のファイル名を返します
+func exportFileName(t time.Time) string {
+	return fmt.Sprintf("export-%s.zip", t.UTC().Format("20060102-150405"))
+}
+
+// runExportJob はエクスポートジョブを実行し、ZIP を dir に保存します
+// 完了後 ttl の間だけダウンロードできます
+func runExportJob(db *gorm.DB, job *ExportJob, dir string, ttl time.Duration) {
+	fail := func(err error) {
+		if err := finishExportJob(db, job.ID, ExportStatusFailed, err.Error(), "", 0, time.Now().Add(ttl)); err != nil {
+			log.Printf("[export] finishExportJob error: %v", err)
+		}
+	}
+	if err := startExportJob(db, job.ID); err != nil {
+		log.Printf("[export] startExportJob error: %v", err)
+		return
+	}
+	u, err := getUserByID(db, job.UserID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		fail(err)
+		return
+	}
+	name, err := GenerateOpaqueToken(16)
+	if err != nil {
+		fail(err)
+		return
+	}
+	path := filepath.Join(dir, fmt.Sprintf("export-%d-%s.zip", job.ID, name))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if err := writeUserExport(f, db, u); err != nil {
+		f.Close()
+		os.Remove(path)
+		fail(err)
+		return
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		fail(err)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if err := finishExportJob(db, job.ID, ExportStatusCompleted, "", path, info.Size(), time.Now().Add(ttl)); err != nil {
+		log.Printf("[export] finishExportJob error: %v", err)
+	}
+}
+
+// cleanupExportFiles は期限切れのエクスポートジョブと、dir に残った ttl より古い ZIP を削除します
+// 退会などでジョブの行だけが消えたファイルも後者で削除されます
+func cleanupExportFiles(db *gorm.DB, dir string, ttl time.Duration, now time.Time) error {
+	if err := deleteExpiredExportJobs(db, now); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".zip" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > ttl {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				log.Printf("[export] remove %s: %v", e.Name(), err)
+			}
+		}
+	}
+	return nil
+}
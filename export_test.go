@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// エクスポートで書き出す行（と予定のリマインダー）がすべて数えられていることを確認する
+func TestCountUserExportRecords(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db)
+	other := newTestUser(t, db)
+	for _, u := range []*User{user, other} {
+		cl := CompanyList{Company: "Acme", UserID: u.ID}
+		in := Internship{Title: "Summer", Company: "Acme", UserID: u.ID}
+		post := Post{Title: "t", Content: "c", DisplayName: "n", UserID: u.ID}
+		for _, record := range []interface{}{&cl, &in, &post} {
+			if err := db.Create(record).Error; err != nil {
+				t.Fatalf("create %T: %v", record, err)
+			}
+		}
+		contact := Contact{UserID: u.ID, CompanyListID: cl.ID, Name: "Recruiter"}
+		event := Event{
+			UserID: u.ID, CompanyListID: &cl.ID, Type: EventTypeInterview, Title: "Interview",
+			StartsAt: time.Now().UTC(), TimeZone: "UTC", Reminders: newEventReminders(time.Now().UTC(), []int{10, 60}),
+		}
+		for _, record := range []interface{}{
+			&Comment{Content: "c", DisplayName: "n", PostID: post.ID, UserID: u.ID},
+			&Like{PostID: post.ID, UserID: u.ID},
+			&StageChange{UserID: u.ID, CompanyListID: cl.ID, ToStage: StageEntry},
+			&Tag{UserID: u.ID, Name: "first", Color: "#000000"},
+			&Note{UserID: u.ID, CompanyListID: cl.ID, Type: NoteTypeResearch, Body: "b"},
+			&contact, &event,
+		} {
+			if err := db.Create(record).Error; err != nil {
+				t.Fatalf("create %T: %v", record, err)
+			}
+		}
+		interaction := ContactInteraction{UserID: u.ID, ContactID: contact.ID, Type: InteractionTypeEmail, OccurredAt: time.Now().UTC()}
+		if err := db.Create(&interaction).Error; err != nil {
+			t.Fatalf("create interaction: %v", err)
+		}
+	}
+
+	datasets, err := loadExportDatasets(db, user.ID)
+	if err != nil {
+		t.Fatalf("loadExportDatasets: %v", err)
+	}
+	want := int64(2) // 予定のリマインダーは events の 1 行にまとめて書き出される
+	for _, ds := range datasets {
+		if len(ds.Rows) == 0 {
+			t.Errorf("dataset %s has no rows, add a record for it to this test", ds.Name)
+		}
+		want += int64(len(ds.Rows))
+	}
+	got, err := countUserExportRecords(db, user.ID)
+	if err != nil {
+		t.Fatalf("countUserExportRecords: %v", err)
+	}
+	if got != want {
+		t.Errorf("countUserExportRecords() = %d, want %d", got, want)
+	}
+}
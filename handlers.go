@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,8 +15,10 @@ import (
 	"gorm.io/gorm"
 )
 
-// authMiddleware はリクエストヘッダーから JWT を検証し、userID をコンテキストにセットします
-func authMiddleware() gin.HandlerFunc {
+// authMiddleware はリクエストヘッダーから JWT または個人アクセストークンを検証し、
+// userID をコンテキストにセットします
+// 失効リストに登録済みのトークンは拒否します
+func authMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		auth := c.GetHeader("Authorization")
 		if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
@@ -20,18 +26,184 @@ func authMiddleware() gin.HandlerFunc {
 			return
 		}
 		tokenStr := strings.TrimPrefix(auth, "Bearer ")
+		if strings.HasPrefix(tokenStr, personalAccessTokenPrefix) {
+			authenticatePersonalAccessToken(c, db, tokenStr)
+			return
+		}
 		claims, err := ParseJWT(tokenStr)
-		if err != nil {
+		if err != nil || claims.IssuedAt == nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
+		revoked, err := isTokenRevoked(db, claims.ID, claims.UserID, claims.IssuedAt.Time)
+		if err != nil {
+			log.Printf("[auth] isTokenRevoked error: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "token check error"})
+			return
+		}
+		if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
+			return
+		}
+		// ログインセッションが失効していれば拒否し、最終アクセス日時を更新する
+		if claims.SessionID != 0 {
+			s, err := getSession(db, claims.SessionID)
+			if err != nil || s.RevokedAt != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+				return
+			}
+			if err := touchSession(db, s.ID, time.Now()); err != nil {
+				log.Printf("[auth] touchSession error: %v", err)
+			}
+		}
 		c.Set("userID", claims.UserID)
+		c.Set("role", claims.Role)
+		c.Set("claims", claims)
+		c.Set("authMethod", "jwt")
+		c.Next()
+	}
+}
+
+// authenticatePersonalAccessToken は個人アクセストークンを検証し、userID とスコープをコンテキストにセットします
+func authenticatePersonalAccessToken(c *gin.Context, db *gorm.DB, tokenStr string) {
+	now := time.Now()
+	pat, err := getPersonalAccessTokenByHash(db, HashToken(tokenStr))
+	if err != nil || pat.RevokedAt != nil || now.After(pat.ExpiresAt) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+	u, err := getUserByID(db, pat.UserID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+	if err := touchPersonalAccessToken(db, pat.ID, now); err != nil {
+		log.Printf("[auth] touchPersonalAccessToken error: %v", err)
+	}
+	c.Set("userID", u.ID)
+	c.Set("role", u.Role)
+	c.Set("scopes", strings.Split(pat.Scopes, ","))
+	c.Set("authMethod", "pat")
+	c.Next()
+}
+
+// requireJWT は個人アクセストークンでのアクセスを拒否するミドルウェアです
+// アカウント管理など、ログインしたユーザー本人だけが行う操作に使います
+func requireJWT() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") != "jwt" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "personal access tokens are not allowed here"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireScope は個人アクセストークンのスコープを確認するミドルウェアです
+// 参照系（GET / HEAD）には read、それ以外には write が必要です。JWT は制限しません
+func requireScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") != "pat" {
+			c.Next()
+			return
+		}
+		need := ScopeWrite
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			need = ScopeRead
+		}
+		for _, s := range c.GetStringSlice("scopes") {
+			if s == need {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient scope", "required_scope": need})
+	}
+}
+
+// requireVerifiedEmail はメールアドレス確認済みのユーザーのみ通すミドルウェアです
+// authMiddleware の後に使います
+func requireVerifiedEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, err := getUserByID(db, c.GetUint("userID"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		if u.VerifiedAt == nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "email not verified", "code": "email_not_verified"})
+			return
+		}
 		c.Next()
 	}
 }
 
-// registerHandler は新規ユーザー登録を行うハンドラを返します
-func registerHandler(db *gorm.DB) gin.HandlerFunc {
+// requireRole は JWT の権限が roles のいずれかであるユーザーのみ通すミドルウェアです
+// authMiddleware の後に使います
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, r := range roles {
+			if role == r {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+	}
+}
+
+// recordAuditEvent は監査ログを 1 件記録します（IP と User-Agent はリクエストから取る）
+// 記録に失敗しても元の処理は続けます
+func recordAuditEvent(c *gin.Context, db *gorm.DB, e AuditEvent) {
+	e.IP = c.ClientIP()
+	e.UserAgent = c.Request.UserAgent()
+	if err := createAuditEvent(db, &e); err != nil {
+		log.Printf("[audit] createAuditEvent error: %v", err)
+	}
+}
+
+// checkPasswordPolicy はパスワードがポリシーを満たさない場合に項目ごとのエラーを返して false を返します
+func checkPasswordPolicy(c *gin.Context, field, password, email string) bool {
+	if errs := passwordPolicy.Validate(field, password, email); len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "password does not meet the policy", "fields": errs})
+		return false
+	}
+	return true
+}
+
+// sendVerificationMail はメールアドレス確認リンクを非同期で送信します
+func sendVerificationMail(mailer Mailer, frontendURL string, u *User) error {
+	token, err := GeneratePurposeToken(u.ID, purposeEmailVerify, u.Email, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/verify-email?token=%s", frontendURL, token)
+	mailBody := fmt.Sprintf("以下のリンクからメールアドレスの確認を完了してください（有効期限: %d 時間）。\n\n%s\n",
+		int(emailVerificationTTL.Hours()), link)
+	go func(to string) {
+		if err := mailer.Send(to, "メールアドレスの確認", mailBody); err != nil {
+			log.Printf("[verify] mail send error: %v", err)
+		}
+	}(u.Email)
+	return nil
+}
+
+// jwksHandler は JWT 検証用の公開鍵一覧（JWKS）を返すハンドラを返します
+// HS256 の共有鍵は公開しません
+func jwksHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := jwks
+		if keys == nil {
+			keys = []JWK{}
+		}
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
+
+// registerHandler は新規ユーザー登録を行い、確認メールを送るハンドラを返します
+func registerHandler(db *gorm.DB, mailer Mailer, frontendURL string) gin.HandlerFunc {
 	type req struct {
 		Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required"`
@@ -42,24 +214,178 @@ func registerHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if !checkPasswordPolicy(c, "password", body.Password, body.Email) {
+			return
+		}
 		pwHash, err := HashPassword(body.Password)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
 			return
 		}
-		if _, err := createUser(db, body.Email, pwHash); err != nil {
+		u, err := createUser(db, body.Email, pwHash)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if err := sendVerificationMail(mailer, frontendURL, u); err != nil {
+			log.Printf("[register] sendVerificationMail error: %v", err)
+		}
 		c.JSON(http.StatusCreated, gin.H{"email": body.Email})
 	}
 }
 
-// loginHandler はログイン認証を行い、JWT を返すハンドラを返します
+// verifyEmailHandler は確認リンクのトークンを検証し、メールアドレスを確認済みにするハンドラを返します
+func verifyEmailHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		claims, err := ParsePurposeToken(body.Token, purposeEmailVerify)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+			return
+		}
+		u, err := getUserByID(db, claims.UserID)
+		if err != nil || u.Email != claims.Email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+			return
+		}
+		if u.VerifiedAt == nil {
+			if _, err := markUserVerified(db, u.ID, claims.Email); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"email": u.Email, "verified": true})
+	}
+}
+
+// resendVerificationHandler は確認メールを再送するハンドラを返します
+func resendVerificationHandler(db *gorm.DB, mailer Mailer, frontendURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, err := getUserByID(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if u.VerifiedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already verified"})
+			return
+		}
+		if err := sendVerificationMail(mailer, frontendURL, u); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+	}
+}
+
+// issueTokens はログインセッションを作成し、アクセストークン（JWT）と
+// 新しい系列のリフレッシュトークンを発行します
+func issueTokens(c *gin.Context, db *gorm.DB, u *User, deviceName string) (gin.H, error) {
+	session := &Session{
+		UserID:     u.ID,
+		DeviceName: deviceName,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		LastSeenAt: time.Now(),
+	}
+	if err := createSession(db, session); err != nil {
+		return nil, err
+	}
+	token, err := GenerateJWT(u.ID, u.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+	familyID, err := GenerateOpaqueToken(16)
+	if err != nil {
+		return nil, err
+	}
+	if err := createRefreshToken(db, &RefreshToken{
+		UserID:     u.ID,
+		TokenHash:  HashToken(refreshToken),
+		FamilyID:   familyID,
+		SessionID:  session.ID,
+		DeviceName: deviceName,
+		ExpiresAt:  time.Now().Add(refreshTokenTTL),
+	}); err != nil {
+		return nil, err
+	}
+	return gin.H{"token": token, "refresh_token": refreshToken, "user_id": u.ID, "session_id": session.ID}, nil
+}
+
+// ログイン失敗を記録する単位
+const (
+	loginKindEmail = "email"
+	loginKindIP    = "ip"
+)
+
+// abortLoginThrottled は Retry-After を付けてログイン試行制限のエラーを返します
+func abortLoginThrottled(c *gin.Context, status int, code string, until time.Time) {
+	retryAfter := int(time.Until(until).Seconds()) + 1
+	c.Header("Retry-After", fmt.Sprint(retryAfter))
+	c.AbortWithStatusJSON(status, gin.H{"error": "too many failed login attempts", "code": code, "retry_after": retryAfter})
+}
+
+// checkLoginThrottle はメールアドレス・IP がロック中またはバックオフ中かを確認します
+// 制限中の場合はエラーレスポンスを返して false を返します
+func checkLoginThrottle(c *gin.Context, db *gorm.DB, email string) bool {
+	now := time.Now()
+	byEmail, err := getLoginAttempt(db, loginKindEmail, strings.ToLower(email))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	byIP, err := getLoginAttempt(db, loginKindIP, c.ClientIP())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if byEmail != nil && byEmail.LockedUntil != nil && byEmail.LockedUntil.After(now) {
+		abortLoginThrottled(c, http.StatusLocked, "account_locked", *byEmail.LockedUntil)
+		return false
+	}
+	if byIP != nil && byIP.LockedUntil != nil && byIP.LockedUntil.After(now) {
+		abortLoginThrottled(c, http.StatusTooManyRequests, "ip_locked", *byIP.LockedUntil)
+		return false
+	}
+	for _, a := range []*LoginAttempt{byEmail, byIP} {
+		if a != nil && a.NextAllowedAt != nil && a.NextAllowedAt.After(now) {
+			abortLoginThrottled(c, http.StatusTooManyRequests, "login_backoff", *a.NextAllowedAt)
+			return false
+		}
+	}
+	return true
+}
+
+// recordLoginFailures はメールアドレス単位と IP 単位でログイン失敗を記録します
+// 存在しないメールアドレスも記録し、ロックの有無から登録状況が分からないようにします
+func recordLoginFailures(c *gin.Context, db *gorm.DB, email string) {
+	now := time.Now()
+	if _, err := recordLoginFailure(db, loginKindEmail, strings.ToLower(email), loginThrottle.MaxFailuresPerEmail, loginThrottle, now); err != nil {
+		log.Printf("[login] recordLoginFailure error: %v", err)
+	}
+	if _, err := recordLoginFailure(db, loginKindIP, c.ClientIP(), loginThrottle.MaxFailuresPerIP, loginThrottle, now); err != nil {
+		log.Printf("[login] recordLoginFailure error: %v", err)
+	}
+}
+
+// loginHandler はログイン認証を行い、JWT とリフレッシュトークンを返すハンドラを返します
+// 失敗が続くと指数バックオフで待たされ、一定回数でアカウントが一時的にロックされます
 func loginHandler(db *gorm.DB) gin.HandlerFunc {
 	type req struct {
-		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required"`
+		Email      string `json:"email" binding:"required,email"`
+		Password   string `json:"password" binding:"required"`
+		DeviceName string `json:"device_name" binding:"max=100"`
 	}
 	return func(c *gin.Context) {
 		var body req
@@ -67,131 +393,1391 @@ func loginHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if !checkLoginThrottle(c, db, body.Email) {
+			recordAuditEvent(c, db, AuditEvent{Type: AuditLogin, Result: AuditResultFailure, Email: body.Email, Detail: "throttled"})
+			return
+		}
 		u, err := getUserByEmail(db, body.Email)
 		if err != nil {
+			recordLoginFailures(c, db, body.Email)
+			recordAuditEvent(c, db, AuditEvent{Type: AuditLogin, Result: AuditResultFailure, Email: body.Email, Detail: "unknown email"})
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 			return
 		}
 		if err := CheckPassword(u.Password, body.Password); err != nil {
+			recordLoginFailures(c, db, body.Email)
+			recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditLogin, Result: AuditResultFailure, Email: body.Email, Detail: "invalid password"})
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 			return
 		}
-		token, err := GenerateJWT(u.ID)
+		// 退会の猶予期間中は POST /account/restore で取り消すまでログインできない
+		if u.DeletionScheduledAt != nil {
+			recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditLogin, Result: AuditResultFailure, Email: u.Email, Detail: "pending deletion"})
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                 "account is scheduled for deletion",
+				"code":                  "account_pending_deletion",
+				"deletion_scheduled_at": u.DeletionScheduledAt,
+			})
+			return
+		}
+		// 二要素認証が有効な場合は JWT の代わりに二要素認証待ちトークンを返す
+		if u.TOTPEnabled {
+			mfaToken, err := GeneratePurposeToken(u.ID, purposeMFAPending, u.Email, mfaPendingTTL)
+			if err != nil {
+				log.Printf("[login] GeneratePurposeToken error: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken})
+			return
+		}
+		// 失敗回数はログインが完了したときだけリセットする
+		// （二要素認証の前にリセットすると、パスワードを知っていればコードの試行制限を外せてしまう）
+		if err := resetLoginAttempts(db, loginKindEmail, strings.ToLower(body.Email)); err != nil {
+			log.Printf("[login] resetLoginAttempts error: %v", err)
+		}
+		deviceName := body.DeviceName
+		if deviceName == "" {
+			deviceName = c.Request.UserAgent()
+		}
+		res, err := issueTokens(c, db, u, deviceName)
 		if err != nil {
-			log.Printf("[login] GenerateJWT error: %v", err)
+			log.Printf("[login] issueTokens error: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"token": token, "user_id": u.ID})
+		recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditLogin, Result: AuditResultSuccess, Email: u.Email, Detail: "password"})
+		c.JSON(http.StatusOK, res)
 	}
 }
 
-// createCompanyListHandler は新規 CompanyList 作成のハンドラ
-func createCompanyListHandler(db *gorm.DB) gin.HandlerFunc {
+// verifySecondFactor は TOTP コードまたはリカバリーコードを検証します
+// 使用したステップ・リカバリーコードは記録し、再利用できないようにします
+func verifySecondFactor(db *gorm.DB, u *User, code string, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := VerifyTOTP(u.TOTPSecret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return markTOTPStepUsed(db, u.ID, step)
+	}
+	if recoveryCode != "" {
+		codes, err := listUnusedRecoveryCodes(db, u.ID)
+		if err != nil {
+			return false, err
+		}
+		normalized := normalizeRecoveryCode(recoveryCode)
+		for _, rc := range codes {
+			if CheckPassword(rc.CodeHash, normalized) == nil {
+				return markRecoveryCodeUsed(db, rc.ID)
+			}
+		}
+	}
+	return false, nil
+}
+
+// mfaLoginHandler は二要素認証待ちトークンと TOTP コード（またはリカバリーコード）を検証し、
+// JWT とリフレッシュトークンを返すハンドラを返します
+func mfaLoginHandler(db *gorm.DB) gin.HandlerFunc {
 	type req struct {
-		Company    string `json:"company" binding:"required"`
-		Occupation string `json:"occupation"`
-		Member     int    `json:"member" binding:"required"`
-		Selection  string `json:"selection"`
-		Intern     bool   `json:"intern"`
+		MFAToken     string `json:"mfa_token" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		DeviceName   string `json:"device_name" binding:"max=100"`
 	}
 	return func(c *gin.Context) {
-		userID := c.GetUint("userID")
 		var body req
 		if err := c.ShouldBindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		cl, err := createCompanyList(
-			db,
-			userID,
-			body.Company,
-			body.Occupation,
-			body.Member,
-			body.Selection,
-			body.Intern,
-		)
+		if body.Code == "" && body.RecoveryCode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code required"})
+			return
+		}
+		claims, err := ParsePurposeToken(body.MFAToken, purposeMFAPending)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+			return
+		}
+		u, err := getUserByID(db, claims.UserID)
+		if err != nil || !u.TOTPEnabled || u.DeletionScheduledAt != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+			return
+		}
+		// コードの総当たりもパスワードと同じ試行制限の対象にする
+		if !checkLoginThrottle(c, db, u.Email) {
+			recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditLogin, Result: AuditResultFailure, Email: u.Email, Detail: "throttled"})
+			return
+		}
+		ok, err := verifySecondFactor(db, u, body.Code, body.RecoveryCode)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusCreated, cl)
+		if !ok {
+			recordLoginFailures(c, db, u.Email)
+			recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditLogin, Result: AuditResultFailure, Email: u.Email, Detail: "invalid second factor"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+		if err := resetLoginAttempts(db, loginKindEmail, strings.ToLower(u.Email)); err != nil {
+			log.Printf("[login] resetLoginAttempts error: %v", err)
+		}
+		deviceName := body.DeviceName
+		if deviceName == "" {
+			deviceName = c.Request.UserAgent()
+		}
+		res, err := issueTokens(c, db, u, deviceName)
+		if err != nil {
+			log.Printf("[login] issueTokens error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		method := "totp"
+		if body.Code == "" {
+			method = "recovery code"
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditLogin, Result: AuditResultSuccess, Email: u.Email, Detail: method})
+		c.JSON(http.StatusOK, res)
 	}
 }
 
-// listCompanyListsHandler はユーザーの CompanyList 一覧を返すハンドラ
-func listCompanyListsHandler(db *gorm.DB) gin.HandlerFunc {
+// enrollTOTPHandler は TOTP のシークレットを発行し、otpauth URI を返すハンドラを返します
+// この時点ではまだ有効化されず、confirmTOTPHandler で最初のコードを確認して有効化します
+func enrollTOTPHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetUint("userID")
-		list, err := listCompanyLists(db, userID)
+		u, err := getUserByID(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if u.TOTPEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "totp already enabled"})
+			return
+		}
+		secret, err := GenerateTOTPSecret()
 		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "secret error"})
+			return
+		}
+		if err := setUserTOTPSecret(db, u.ID, secret); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, list)
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": TOTPURI(secret, u.Email)})
 	}
 }
 
-// updateCompanyListHandler は既存 CompanyList 更新のハンドラ
-func updateCompanyListHandler(db *gorm.DB) gin.HandlerFunc {
+// confirmTOTPHandler は最初の TOTP コードを確認して二要素認証を有効化し、
+// リカバリーコードを返すハンドラを返します（リカバリーコードはこのレスポンスでのみ表示）
+func confirmTOTPHandler(db *gorm.DB) gin.HandlerFunc {
 	type req struct {
-		Company    string `json:"company" binding:"required"`
-		Occupation string `json:"occupation"`
-		Member     int    `json:"member" binding:"required"`
-		Selection  string `json:"selection"`
-		Intern     bool   `json:"intern"`
+		Code string `json:"code" binding:"required"`
 	}
 	return func(c *gin.Context) {
-		userID := c.GetUint("userID")
 		var body req
 		if err := c.ShouldBindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var id uint
-		fmt.Sscanf(c.Param("id"), "%d", &id)
-		if err := updateCompanyList(
-			db,
-			id,
-			userID,
-			body.Company,
-			body.Occupation,
-			body.Member,
-			body.Selection,
-			body.Intern,
-		); err != nil {
+		u, err := getUserByID(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if u.TOTPEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "totp already enabled"})
+			return
+		}
+		if u.TOTPSecret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "totp enrollment not started"})
+			return
+		}
+		step, ok := VerifyTOTP(u.TOTPSecret, body.Code, time.Now())
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+		codes, err := GenerateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "recovery code error"})
+			return
+		}
+		hashes := make([]string, len(codes))
+		for i, code := range codes {
+			if hashes[i], err = HashPassword(code); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+				return
+			}
+		}
+		if err := enableUserTOTP(db, u.ID, step, hashes); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.Status(http.StatusNoContent)
+		recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditMFAEnable, Result: AuditResultSuccess})
+		c.JSON(http.StatusOK, gin.H{"totp_enabled": true, "recovery_codes": codes})
 	}
 }
 
-// deleteCompanyListHandler は既存 CompanyList 削除のハンドラ
-func deleteCompanyListHandler(db *gorm.DB) gin.HandlerFunc {
+// disableTOTPHandler はパスワードと TOTP コード（またはリカバリーコード）を確認して二要素認証を無効化するハンドラを返します
+// 失敗はログインと同じ試行制限の対象になります
+func disableTOTPHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
 	return func(c *gin.Context) {
-		userID := c.GetUint("userID")
-		var id uint
-		fmt.Sscanf(c.Param("id"), "%d", &id)
-		if err := deleteCompanyList(db, id, userID); err != nil {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		u, err := getUserByID(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if u.TOTPEnabled && body.Code == "" && body.RecoveryCode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code required"})
+			return
+		}
+		// パスワード・コードの総当たりもログインと同じ試行制限の対象にする
+		if !checkLoginThrottle(c, db, u.Email) {
+			recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditMFADisable, Result: AuditResultFailure, Detail: "throttled"})
+			return
+		}
+		if err := CheckPassword(u.Password, body.Password); err != nil {
+			recordLoginFailures(c, db, u.Email)
+			recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditMFADisable, Result: AuditResultFailure, Detail: "invalid password"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			return
+		}
+		if u.TOTPEnabled {
+			ok, err := verifySecondFactor(db, u, body.Code, body.RecoveryCode)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !ok {
+				recordLoginFailures(c, db, u.Email)
+				recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditMFADisable, Result: AuditResultFailure, Detail: "invalid second factor"})
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+				return
+			}
+		}
+		if err := disableUserTOTP(db, u.ID); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		// 失敗回数は無効化が完了したときだけリセットする
+		if err := resetLoginAttempts(db, loginKindEmail, strings.ToLower(u.Email)); err != nil {
+			log.Printf("[mfa] resetLoginAttempts error: %v", err)
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditMFADisable, Result: AuditResultSuccess})
 		c.Status(http.StatusNoContent)
 	}
 }
 
+// refreshTokenHandler はリフレッシュトークンをローテーションし、新しいトークンを返すハンドラを返します
+// ローテーション済みのトークンが再利用された場合は盗用とみなし、系列ごと失効させます
+func refreshTokenHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rt, err := getRefreshTokenByHash(db, HashToken(body.RefreshToken))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		if rt.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		if rt.RotatedAt != nil {
+			revokeReusedRefreshToken(c, db, rt)
+			return
+		}
+		// 権限の変更を反映するため、ユーザーを取り直す
+		u, err := getUserByID(db, rt.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		if rt.SessionID != 0 {
+			s, err := getSession(db, rt.SessionID)
+			if err != nil || s.RevokedAt != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+				return
+			}
+			if err := touchSession(db, s.ID, time.Now()); err != nil {
+				log.Printf("[refresh] touchSession error: %v", err)
+			}
+		} else {
+			// セッション導入前に発行されたリフレッシュトークンはここでセッションを作る
+			s := &Session{
+				UserID:     rt.UserID,
+				DeviceName: rt.DeviceName,
+				UserAgent:  c.Request.UserAgent(),
+				IP:         c.ClientIP(),
+				LastSeenAt: time.Now(),
+			}
+			if err := createSession(db, s); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			rt.SessionID = s.ID
+		}
 
-//インターンシップ作成handler処理
-func createInternshipHandler(db *gorm.DB) gin.HandlerFunc {
-    type req struct {
-        Title       string `json:"title" binding:"required"`
-        Company     string `json:"company" binding:"required"`
-        Dailystart  int    `json:"dailystart" binding:"required"`
-        Dailyfinish int    `json:"dailyfinish" binding:"required"`
-        Content     string `json:"content"`
-        Selection   string `json:"selection" binding:"required"`
+		refreshToken, err := GenerateOpaqueToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		if _, err := rotateRefreshToken(db, rt, HashToken(refreshToken), time.Now().Add(refreshTokenTTL)); err != nil {
+			if errors.Is(err, errRefreshTokenReused) {
+				revokeReusedRefreshToken(c, db, rt)
+				return
+			}
+			log.Printf("[refresh] rotateRefreshToken error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		token, err := GenerateJWT(u.ID, u.Role, rt.SessionID)
+		if err != nil {
+			log.Printf("[refresh] GenerateJWT error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken, "user_id": rt.UserID})
+	}
+}
+
+// revokeReusedRefreshToken は再利用されたリフレッシュトークンの系列を失効させ、401 を返します
+func revokeReusedRefreshToken(c *gin.Context, db *gorm.DB, rt *RefreshToken) {
+	log.Printf("[refresh] reuse detected: user_id=%d family=%s", rt.UserID, rt.FamilyID)
+	if err := revokeRefreshTokenFamily(db, rt.FamilyID); err != nil {
+		log.Printf("[refresh] revokeRefreshTokenFamily error: %v", err)
+	}
+	if rt.SessionID != 0 {
+		if _, err := revokeSession(db, rt.SessionID, rt.UserID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("[refresh] revokeSession error: %v", err)
+		}
+	}
+	recordAuditEvent(c, db, AuditEvent{UserID: rt.UserID, Type: AuditTokenRevoke, Result: AuditResultSuccess, Detail: "refresh token reuse detected"})
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token reused"})
+}
+
+// logoutHandler は現在のアクセストークンとログインセッションを失効させるハンドラを返します
+// refresh_token が渡された場合はその系列（端末）のリフレッシュトークンも失効させます
+func logoutHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		claims := c.MustGet("claims").(*Claims)
+		var body req
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if err := revokeToken(db, claims.ID, userID, claims.ExpiresAt.Time); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if claims.SessionID != 0 {
+			if _, err := revokeSession(db, claims.SessionID, userID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if body.RefreshToken != "" {
+			rt, err := getRefreshTokenByHash(db, HashToken(body.RefreshToken))
+			if err == nil && rt.UserID == userID {
+				if err := revokeRefreshTokenFamily(db, rt.FamilyID); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditTokenRevoke, Result: AuditResultSuccess, Detail: "logout"})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// logoutAllHandler はユーザーの全端末のトークンを失効させるハンドラを返します
+func logoutAllHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		if err := revokeAllUserTokens(db, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := revokeUserSessions(db, userID, 0); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditTokenRevoke, Result: AuditResultSuccess, Detail: "logout all devices"})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// listSessionsHandler はログイン中の端末（有効なセッション）一覧を返すハンドラを返します
+func listSessionsHandler(db *gorm.DB) gin.HandlerFunc {
+	type SessionResponse struct {
+		Session
+		Current bool `json:"current"` // このリクエストのセッションか
+	}
+	return func(c *gin.Context) {
+		sessions, err := listActiveSessions(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		currentID := c.MustGet("claims").(*Claims).SessionID
+		response := make([]SessionResponse, 0, len(sessions))
+		for _, s := range sessions {
+			response = append(response, SessionResponse{Session: s, Current: s.ID == currentID})
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// revokeSessionHandler は指定した端末のセッションを失効させる（リモートログアウト）ハンドラを返します
+func revokeSessionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		session, err := revokeSession(db, id, c.GetUint("userID"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: c.GetUint("userID"), Type: AuditTokenRevoke, Result: AuditResultSuccess, Detail: fmt.Sprintf("session %d", id)})
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// changePasswordHandler は現在のパスワードを確認してパスワードを変更するハンドラを返します
+// 変更後はこの端末以外のセッションを失効させます
+func changePasswordHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := c.GetUint("userID")
+		u, err := getUserByID(db, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err := CheckPassword(u.Password, body.CurrentPassword); err != nil {
+			recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditPasswordChange, Result: AuditResultFailure, Detail: "invalid current password"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+			return
+		}
+		if !checkPasswordPolicy(c, "new_password", body.NewPassword, u.Email) {
+			return
+		}
+		pwHash, err := HashPassword(body.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+			return
+		}
+		if err := updateUserPassword(db, userID, pwHash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		claims := c.MustGet("claims").(*Claims)
+		if err := revokeUserSessions(db, userID, claims.SessionID); err != nil {
+			log.Printf("[password] revokeUserSessions error: %v", err)
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditPasswordChange, Result: AuditResultSuccess})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// changeEmailHandler はパスワードを確認し、新しいメールアドレスに確認リンクを送るハンドラを返します
+// リンクが確認されるまでメールアドレスは変わりません
+func changeEmailHandler(db *gorm.DB, mailer Mailer, frontendURL string) gin.HandlerFunc {
+	type req struct {
+		Email    string `json:"email" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		u, err := getUserByID(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err := CheckPassword(u.Password, body.Password); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+			return
+		}
+		if body.Email == u.Email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is unchanged"})
+			return
+		}
+		taken, err := emailExists(db, body.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": errEmailTaken.Error()})
+			return
+		}
+		token, err := GenerateOpaqueToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		if err := createEmailChangeRequest(db, &EmailChangeRequest{
+			UserID:    u.ID,
+			OldEmail:  u.Email,
+			NewEmail:  body.Email,
+			TokenHash: HashToken(token),
+			ExpiresAt: time.Now().Add(emailChangeTTL),
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		link := fmt.Sprintf("%s/email/change?token=%s", frontendURL, token)
+		mailBody := fmt.Sprintf("以下のリンクからメールアドレスの変更を完了してください（有効期限: %d 時間）。\n\n%s\n\nこのメールに心当たりがない場合は破棄してください。\n",
+			int(emailChangeTTL.Hours()), link)
+		go func(to string) {
+			if err := mailer.Send(to, "メールアドレス変更の確認", mailBody); err != nil {
+				log.Printf("[email] mail send error: %v", err)
+			}
+		}(body.Email)
+		c.JSON(http.StatusAccepted, gin.H{"message": "confirmation email sent", "email": body.Email})
+	}
+}
+
+// confirmEmailChangeHandler は確認リンクのトークンを消費してメールアドレスを切り替えるハンドラを返します
+// 切り替え後、旧アドレスに変更の通知を送ります
+func confirmEmailChangeHandler(db *gorm.DB, mailer Mailer) gin.HandlerFunc {
+	type req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		r, err := consumeEmailChangeRequest(db, HashToken(body.Token))
+		if err != nil {
+			switch {
+			case errors.Is(err, errInvalidEmailChangeToken):
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			case errors.Is(err, errEmailTaken):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: r.UserID, Type: AuditEmailChange, Result: AuditResultSuccess, Email: r.NewEmail, Detail: "from " + r.OldEmail})
+		mailBody := fmt.Sprintf("アカウントのメールアドレスが %s に変更されました。\n\nこの変更に心当たりがない場合はサポートまでご連絡ください。\n", r.NewEmail)
+		go func(to string) {
+			if err := mailer.Send(to, "メールアドレス変更のお知らせ", mailBody); err != nil {
+				log.Printf("[email] mail send error: %v", err)
+			}
+		}(r.OldEmail)
+		c.JSON(http.StatusOK, gin.H{"email": r.NewEmail})
+	}
+}
+
+// deleteAccountHandler はパスワードを再入力させて退会するハンドラを返します
+// 猶予期間が設定されている場合は削除を予約して全端末をログアウトさせ、
+// 期間内であれば POST /account/restore で取り消せます
+func deleteAccountHandler(db *gorm.DB, gracePeriod time.Duration, postPolicy string) gin.HandlerFunc {
+	type req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := c.GetUint("userID")
+		u, err := getUserByID(db, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err := CheckPassword(u.Password, body.Password); err != nil {
+			recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditAccountDelete, Result: AuditResultFailure, Detail: "invalid password"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+			return
+		}
+
+		if gracePeriod <= 0 {
+			if err := purgeUser(db, userID, postPolicy); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditAccountDelete, Result: AuditResultSuccess, Email: u.Email, Detail: "purged"})
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		scheduledAt := time.Now().Add(gracePeriod)
+		if err := scheduleUserDeletion(db, userID, scheduledAt); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusConflict, gin.H{"error": "account deletion already scheduled"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := revokeAllUserTokens(db, userID); err != nil {
+			log.Printf("[account] revokeAllUserTokens error: %v", err)
+		}
+		if err := revokeUserSessions(db, userID, 0); err != nil {
+			log.Printf("[account] revokeUserSessions error: %v", err)
+		}
+		if err := revokeUserPersonalAccessTokens(db, userID); err != nil {
+			log.Printf("[account] revokeUserPersonalAccessTokens error: %v", err)
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditAccountDelete, Result: AuditResultSuccess, Email: u.Email, Detail: "scheduled"})
+		c.JSON(http.StatusAccepted, gin.H{"deletion_scheduled_at": scheduledAt})
+	}
+}
+
+// restoreAccountHandler は猶予期間中の退会を取り消すハンドラを返します
+// 全端末がログアウト済みのため、メールアドレスとパスワード（二要素認証が有効ならコードも）で本人確認します
+func restoreAccountHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Email        string `json:"email" binding:"required,email"`
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !checkLoginThrottle(c, db, body.Email) {
+			return
+		}
+		u, err := getUserByEmail(db, body.Email)
+		if err != nil || CheckPassword(u.Password, body.Password) != nil {
+			recordLoginFailures(c, db, body.Email)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			return
+		}
+		if u.TOTPEnabled {
+			ok, err := verifySecondFactor(db, u, body.Code, body.RecoveryCode)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !ok {
+				recordLoginFailures(c, db, body.Email)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+				return
+			}
+		}
+		restored, err := cancelUserDeletion(db, u.ID, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !restored {
+			c.JSON(http.StatusConflict, gin.H{"error": "account is not scheduled for deletion"})
+			return
+		}
+		// 失敗回数は取り消しが完了したときだけリセットする
+		if err := resetLoginAttempts(db, loginKindEmail, strings.ToLower(body.Email)); err != nil {
+			log.Printf("[account] resetLoginAttempts error: %v", err)
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: u.ID, Type: AuditAccountRestore, Result: AuditResultSuccess, Email: u.Email})
+		c.JSON(http.StatusOK, gin.H{"email": u.Email, "restored": true})
+	}
+}
+
+// exportDataHandler は自分のデータ一式を ZIP でエクスポートするハンドラを返します
+// レコード数が syncMaxRecords 以下ならその場でストリーミングし、
+// それを超える場合や async=true の場合は非同期ジョブを作成して 202 を返します
+func exportDataHandler(db *gorm.DB, dir string, syncMaxRecords int, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		u, err := getUserByID(db, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		count, err := countUserExportRecords(db, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if c.Query("async") != "true" && count <= int64(syncMaxRecords) {
+			c.Header("Content-Type", "application/zip")
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFileName(time.Now())))
+			recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditDataExport, Result: AuditResultSuccess, Detail: "download"})
+			c.Status(http.StatusOK)
+			if err := writeUserExport(c.Writer, db, u); err != nil {
+				// ヘッダー送信後のためステータスは変えられない
+				log.Printf("[export] writeUserExport error: %v", err)
+			}
+			return
+		}
+
+		// 実行中のジョブがあればそれを返す
+		job, err := getActiveExportJob(db, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if job == nil {
+			job = &ExportJob{UserID: userID, Status: ExportStatusPending}
+			if err := createExportJob(db, job); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			go runExportJob(db, job, dir, ttl)
+			recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditDataExport, Result: AuditResultSuccess, Detail: fmt.Sprintf("job %d", job.ID)})
+		}
+		c.Header("Location", fmt.Sprintf("/me/export/jobs/%d", job.ID))
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// exportJobHandler はエクスポートジョブの状態を返すハンドラを返します
+func exportJobHandler(db *gorm.DB) gin.HandlerFunc {
+	type JobResponse struct {
+		*ExportJob
+		DownloadURL string `json:"download_url,omitempty"`
+	}
+	return func(c *gin.Context) {
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		job, err := getExportJob(db, id, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "export job not found"})
+			return
+		}
+		res := JobResponse{ExportJob: job}
+		if job.Status == ExportStatusCompleted {
+			res.DownloadURL = fmt.Sprintf("/me/export/jobs/%d/download", job.ID)
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// downloadExportHandler は完了したエクスポートジョブの ZIP を返すハンドラを返します
+func downloadExportHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		job, err := getExportJob(db, id, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "export job not found"})
+			return
+		}
+		if job.Status != ExportStatusCompleted {
+			c.JSON(http.StatusConflict, gin.H{"error": "export is not ready", "status": job.Status})
+			return
+		}
+		if job.ExpiresAt != nil && job.ExpiresAt.Before(time.Now()) {
+			c.JSON(http.StatusGone, gin.H{"error": "export has expired"})
+			return
+		}
+		c.FileAttachment(job.FilePath, exportFileName(*job.CompletedAt))
+	}
+}
+
+// forgotPasswordHandler はパスワードリセット用のトークンを発行し、メールで送るハンドラを返します
+// メールアドレスの登録有無が分からないよう、常に同じレスポンスを返します
+func forgotPasswordHandler(db *gorm.DB, mailer Mailer, frontendURL string) gin.HandlerFunc {
+	type req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		accepted := gin.H{"message": "if the email is registered, a reset link has been sent"}
+		u, err := getUserByEmail(db, body.Email)
+		if err != nil {
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+		token, err := GenerateOpaqueToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		if err := createPasswordResetToken(db, &PasswordResetToken{
+			UserID:    u.ID,
+			TokenHash: HashToken(token),
+			ExpiresAt: time.Now().Add(passwordResetTTL),
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		link := fmt.Sprintf("%s/password/reset?token=%s", frontendURL, token)
+		mailBody := fmt.Sprintf("以下のリンクからパスワードを再設定してください（有効期限: %d 分）。\n\n%s\n\nこのメールに心当たりがない場合は破棄してください。\n",
+			int(passwordResetTTL.Minutes()), link)
+		// 送信に時間がかかってもレスポンス時間から登録有無が推測されないよう非同期で送る
+		go func(to string) {
+			if err := mailer.Send(to, "パスワード再設定のご案内", mailBody); err != nil {
+				log.Printf("[password] mail send error: %v", err)
+			}
+		}(u.Email)
+		c.JSON(http.StatusAccepted, accepted)
+	}
+}
+
+// resetPasswordHandler はリセットトークンを消費して新しいパスワードを設定するハンドラを返します
+// 再設定後は全端末のトークンを失効させます
+func resetPasswordHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// ポリシーの確認にメールアドレスが要るため、先にトークンの持ち主を調べる
+		t, err := getPasswordResetToken(db, HashToken(body.Token))
+		if err != nil {
+			if errors.Is(err, errInvalidResetToken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		u, err := getUserByID(db, t.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidResetToken.Error()})
+			return
+		}
+		if !checkPasswordPolicy(c, "password", body.Password, u.Email) {
+			return
+		}
+		pwHash, err := HashPassword(body.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+			return
+		}
+		userID, err := consumePasswordResetToken(db, HashToken(body.Token), pwHash)
+		if err != nil {
+			if errors.Is(err, errInvalidResetToken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := revokeAllUserTokens(db, userID); err != nil {
+			log.Printf("[password] revokeAllUserTokens error: %v", err)
+		}
+		if err := revokeUserSessions(db, userID, 0); err != nil {
+			log.Printf("[password] revokeUserSessions error: %v", err)
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditPasswordReset, Result: AuditResultSuccess})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// respondStageError はステージの検証エラーをレスポンスに変換します
+func respondStageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errUnknownStage), errors.Is(err, errInvalidStageTransition):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "field": "stage"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// nextStage は更新後のステージを決めます
+// stage が明示された場合は遷移を検証し、選考状況の文字列から推定した場合は
+// 許される遷移のときだけ反映します（許されなければ現在のステージのまま）
+func nextStage(db *gorm.DB, userID uint, current, stage, selection string) (next string, label string, err error) {
+	next, label, err = resolveStage(db, userID, stage, selection)
+	if err != nil || next == "" || next == current {
+		return next, label, err
+	}
+	if err := validateStageTransition(db, userID, current, next); err != nil {
+		if stage == "" && errors.Is(err, errInvalidStageTransition) {
+			return current, label, nil
+		}
+		return "", "", err
+	}
+	return next, label, nil
+}
+
+// parseIDParam はパスパラメータ name を ID（1 以上の整数）として読み取ります
+// 不正な値の場合は 400 を返し、ok=false になります
+func parseIDParam(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// parseStageChangedAt はステージ変更日時（RFC 3339、省略時は現在時刻）を読み取ります
+// 後から記録するための過去の日時は指定できますが、未来の日時は指定できません
+func parseStageChangedAt(value string) (time.Time, error) {
+	now := time.Now()
+	if value == "" {
+		return now, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("stage_changed_at must be an RFC 3339 timestamp")
+	}
+	if t.After(now) {
+		return time.Time{}, errors.New("stage_changed_at must not be in the future")
+	}
+	return t, nil
+}
+
+// PATCH のステージ関連の項目
+type stagePatch struct {
+	Stage        string
+	HasStage     bool
+	Selection    string
+	HasSelection bool
+	ChangedAt    time.Time
+	HasChangedAt bool
+	Note         string
+}
+
+// takeStagePatch はパッチから stage・selection・stage_changed_at・stage_note を取り出します
+func takeStagePatch(patch map[string]json.RawMessage) (stagePatch, error) {
+	var sp stagePatch
+	var err error
+	if sp.Stage, sp.HasStage, err = takePatchString(patch, "stage"); err != nil {
+		return sp, err
+	}
+	if sp.Selection, sp.HasSelection, err = takePatchString(patch, "selection"); err != nil {
+		return sp, err
+	}
+	var changedAt string
+	if changedAt, sp.HasChangedAt, err = takePatchString(patch, "stage_changed_at"); err != nil {
+		return sp, err
+	}
+	if sp.ChangedAt, err = parseStageChangedAt(changedAt); err != nil {
+		return sp, err
+	}
+	if sp.Note, _, err = takePatchString(patch, "stage_note"); err != nil {
+		return sp, err
+	}
+	return sp, nil
+}
+
+// apply は stage・selection が含まれていれば遷移を検証し、updates に追加します
+// stage は null・空文字にできません（selection は空にできます）
+// selection を含まない場合だけ、ステージの表示名を selection にします
+func (sp stagePatch) apply(db *gorm.DB, userID uint, current string, updates map[string]interface{}) error {
+	if !sp.HasStage && !sp.HasSelection {
+		return nil
+	}
+	if sp.HasStage && sp.Stage == "" {
+		return errUnknownStage
+	}
+	stage, selection, err := nextStage(db, userID, current, sp.Stage, sp.Selection)
+	if err != nil {
+		return err
+	}
+	if stage == "" {
+		stage = current
+	}
+	if sp.HasSelection {
+		selection = sp.Selection
+	}
+	updates["stage"] = stage
+	updates["selection"] = selection
+	return nil
+}
+
+// recordCompanyStageChange はステージまたは選考状況が変わった場合にタイムラインへ記録します
+func recordCompanyStageChange(db *gorm.DB, userID, companyListID uint, fromStage, toStage, fromSelection, toSelection string, changedAt time.Time, note string) {
+	if fromStage == toStage && fromSelection == toSelection {
+		return
+	}
+	if err := createStageChange(db, &StageChange{
+		UserID:        userID,
+		CompanyListID: companyListID,
+		FromStage:     fromStage,
+		ToStage:       toStage,
+		Selection:     toSelection,
+		ChangedAt:     changedAt,
+		Note:          note,
+	}); err != nil {
+		log.Printf("[timeline] createStageChange error: %v", err)
+	}
+}
+
+// createCompanyListHandler は新規 CompanyList 作成のハンドラ
+// stage を省略した場合は selection の文字列からステージを推定します
+func createCompanyListHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Company    string `json:"company" binding:"required"`
+		Occupation string `json:"occupation"`
+		Member     *int   `json:"member" binding:"required"` // 0 も受け付けるためポインタ
+		Selection  string `json:"selection"`
+		Stage      string `json:"stage"`
+		Intern     bool   `json:"intern"`
+		// タイムラインに記録するステージ変更日時（後から記録する場合）とメモ
+		StageChangedAt string `json:"stage_changed_at"`
+		StageNote      string `json:"stage_note"`
+	}
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		changedAt, err := parseStageChangedAt(body.StageChangedAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stage, selection, err := resolveStage(db, userID, body.Stage, body.Selection)
+		if err != nil {
+			respondStageError(c, err)
+			return
+		}
+		cl, err := createCompanyList(
+			db,
+			userID,
+			body.Company,
+			body.Occupation,
+			*body.Member,
+			selection,
+			stage,
+			body.Intern,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordCompanyStageChange(db, userID, cl.ID, "", stage, "", selection, changedAt, body.StageNote)
+		c.JSON(http.StatusCreated, cl)
+	}
+}
+
+// listCompanyListsHandler はユーザーの CompanyList 一覧を返すハンドラ
+// stage・intern・member_min/member_max・company（部分一致）・created/updated の範囲で絞り込み、
+// sort で並び替え、limit と cursor でページングします
+func listCompanyListsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		q, err := parseCompanyListQuery(c, db, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		list, total, more, err := queryCompanyLists(db, userID, q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var next string
+		if more {
+			next = q.Cursor(list[len(list)-1].sortValues(q.Sort))
+		}
+		setListHeaders(c, total, next)
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// parseCompanyListQuery は CompanyList 一覧のクエリパラメータを読み取ります
+func parseCompanyListQuery(c *gin.Context, db *gorm.DB, userID uint) (ListQuery, error) {
+	q, err := parseListQuery(c, companyListSortFields)
+	if err != nil {
+		return q, err
+	}
+	if err := parseStageFilter(c, db, &q, userID); err != nil {
+		return q, err
+	}
+	if err := parseBoolFilter(c, &q, "intern", "intern"); err != nil {
+		return q, err
+	}
+	for _, p := range []struct {
+		name string
+		op   string
+	}{{"member_min", " >= ?"}, {"member_max", " <= ?"}} {
+		if v := c.Query(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an integer", p.name)
+			}
+			q.Where("member"+p.op, n)
+		}
+	}
+	parseContainsFilter(c, &q, "company", "company")
+	if err := parseTimeRangeFilter(c, &q, "created", "created_at"); err != nil {
+		return q, err
+	}
+	if err := parseTimeRangeFilter(c, &q, "updated", "updated_at"); err != nil {
+		return q, err
+	}
+	if err := parseTagFilter(c, db, &q, userID, "company_list_tags", "company_list_id"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// updateCompanyListHandler は既存 CompanyList 更新のハンドラ
+// ステージの後戻りなど許されない遷移は 422 を返します
+func updateCompanyListHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Company    string `json:"company" binding:"required"`
+		Occupation string `json:"occupation"`
+		Member     *int   `json:"member" binding:"required"` // 0 も受け付けるためポインタ
+		Selection  string `json:"selection"`
+		Stage      string `json:"stage"`
+		Intern     bool   `json:"intern"`
+		// タイムラインに記録するステージ変更日時（後から記録する場合）とメモ
+		StageChangedAt string `json:"stage_changed_at"`
+		StageNote      string `json:"stage_note"`
+	}
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		changedAt, err := parseStageChangedAt(body.StageChangedAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		current, err := getCompanyList(db, id, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+			return
+		}
+		stage, _, err := nextStage(db, userID, current.Stage, body.Stage, body.Selection)
+		if err != nil {
+			respondStageError(c, err)
+			return
+		}
+		// ステージが判定できない（または推定したステージに進めない）場合は現在のステージを引き継ぐ
+		if stage == "" {
+			stage = current.Stage
+		}
+		// 選考状況は送られた値（空文字も含む）をそのまま保存する
+		selection := body.Selection
+		if err := updateCompanyList(
+			db,
+			id,
+			userID,
+			body.Company,
+			body.Occupation,
+			*body.Member,
+			selection,
+			stage,
+			body.Intern,
+		); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordCompanyStageChange(db, userID, id, current.Stage, stage, current.Selection, selection, changedAt, body.StageNote)
+		cl, err := getCompanyList(db, id, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cl)
+	}
+}
+
+// CompanyList の PATCH で更新できる項目（stage・selection は別に扱う）
+var companyListPatchFields = map[string]patchField{
+	"company":    {Column: "company", Kind: patchString, NotEmpty: true},
+	"occupation": {Column: "occupation", Kind: patchString},
+	"member":     {Column: "member", Kind: patchInt},
+	"intern":     {Column: "intern", Kind: patchBool},
+}
+
+// patchCompanyListHandler は CompanyList を JSON Merge Patch で部分更新するハンドラ
+// 含まれない項目は変更せず、明示された false・0・空文字は更新します
+func patchCompanyListHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		patch, err := readMergePatch(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sp, err := takeStagePatch(patch)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates, err := patchUpdates(patch, companyListPatchFields)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		current, err := getCompanyList(db, id, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+			return
+		}
+		if err := sp.apply(db, userID, current.Stage, updates); err != nil {
+			respondStageError(c, err)
+			return
+		}
+		cl, err := patchCompanyList(db, id, userID, updates)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordCompanyStageChange(db, userID, id, current.Stage, cl.Stage, current.Selection, cl.Selection, sp.ChangedAt, sp.Note)
+		c.JSON(http.StatusOK, cl)
+	}
+}
+
+// reorderCompanyListHandler は CompanyList の志望順位を変更するハンドラ
+// id のレコードを before_id の直前、または after_id の直後に移動します（どちらか一方を指定）
+func reorderCompanyListHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		ID       uint `json:"id" binding:"required"`
+		BeforeID uint `json:"before_id" binding:"required_without=AfterID,excluded_with=AfterID"`
+		AfterID  uint `json:"after_id"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		anchorID, before := body.AfterID, false
+		if body.BeforeID != 0 {
+			anchorID, before = body.BeforeID, true
+		}
+		if anchorID == body.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot move a company list relative to itself"})
+			return
+		}
+		cl, rebalanced, err := moveCompanyList(db, c.GetUint("userID"), body.ID, anchorID, before)
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+			case errors.Is(err, errUnknownRankAnchor):
+				field := "after_id"
+				if before {
+					field = "before_id"
+				}
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "field": field})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		if rebalanced {
+			log.Printf("[rank] rebalanced company list ranks for user %d", c.GetUint("userID"))
+		}
+		c.JSON(http.StatusOK, cl)
+	}
+}
+
+// deleteCompanyListHandler は既存 CompanyList 削除のハンドラ
+func deleteCompanyListHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		cl, err := deleteCompanyList(db, id, userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cl)
+	}
+}
+
+
+//インターンシップ作成handler処理
+func createInternshipHandler(db *gorm.DB) gin.HandlerFunc {
+    type req struct {
+        Title       string `json:"title" binding:"required"`
+        Company     string `json:"company" binding:"required"`
+        Dailystart  *int   `json:"dailystart" binding:"required"` // 0 も受け付けるためポインタ
+        Dailyfinish *int   `json:"dailyfinish" binding:"required"`
+        Content     string `json:"content"`
+        Selection   string `json:"selection" binding:"required_without=Stage"`
+        Stage       string `json:"stage"`
         Joined      bool   `json:"joined"`
+        StartsOn    string `json:"starts_on" binding:"omitempty,datetime=2006-01-02"` // 実施期間
+        EndsOn      string `json:"ends_on" binding:"omitempty,datetime=2006-01-02"`
     }
     return func(c *gin.Context) {
         userID := c.GetUint("userID")
@@ -200,16 +1786,28 @@ func createInternshipHandler(db *gorm.DB) gin.HandlerFunc {
             c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
             return
         }
+        if err := validateInternshipPeriod(body.StartsOn, body.EndsOn); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        stage, selection, err := resolveStage(db, userID, body.Stage, body.Selection)
+        if err != nil {
+            respondStageError(c, err)
+            return
+        }
         i, err := createInternship(
             db,
             userID,
             body.Title,
             body.Company,
-            body.Dailystart,
-            body.Dailyfinish,
+            *body.Dailystart,
+            *body.Dailyfinish,
             body.Content,
-            body.Selection,
+            selection,
+            stage,
             body.Joined,
+            body.StartsOn,
+            body.EndsOn,
         )
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -219,270 +1817,2026 @@ func createInternshipHandler(db *gorm.DB) gin.HandlerFunc {
     }
 }
 
-//インターンシップ更新handler処理
-func updateInternshipHandler(db *gorm.DB) gin.HandlerFunc {
-    type req struct {
-        Title       string `json:"title" binding:"required"`
-        Company     string `json:"company" binding:"required"`
-        Dailystart  int    `json:"dailystart" binding:"required"`
-        Dailyfinish int    `json:"dailyfinish" binding:"required"`
-        Content     string `json:"content"`
-        Selection   string `json:"selection" binding:"required"`
-        Joined      bool   `json:"joined"`
+//インターンシップ更新handler処理
+func updateInternshipHandler(db *gorm.DB) gin.HandlerFunc {
+    type req struct {
+        Title       string `json:"title" binding:"required"`
+        Company     string `json:"company" binding:"required"`
+        Dailystart  *int   `json:"dailystart" binding:"required"` // 0 も受け付けるためポインタ
+        Dailyfinish *int   `json:"dailyfinish" binding:"required"`
+        Content     string `json:"content"`
+        Selection   string `json:"selection" binding:"required_without=Stage"`
+        Stage       string `json:"stage"`
+        Joined      bool   `json:"joined"`
+        StartsOn    string `json:"starts_on" binding:"omitempty,datetime=2006-01-02"` // 実施期間
+        EndsOn      string `json:"ends_on" binding:"omitempty,datetime=2006-01-02"`
+	}
+    
+    return func(c *gin.Context) {
+        userID := c.GetUint("userID")
+        var body req
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validateInternshipPeriod(body.StartsOn, body.EndsOn); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        id, ok := parseIDParam(c, "id")
+        if !ok {
+            return
+        }
+        current, err := getInternship(db, id, userID)
+        if err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "internship not found"})
+            return
+        }
+        stage, _, err := nextStage(db, userID, current.Stage, body.Stage, body.Selection)
+        if err != nil {
+            respondStageError(c, err)
+            return
+        }
+        // ステージが判定できない（または推定したステージに進めない）場合は現在のステージを引き継ぐ
+        if stage == "" {
+            stage = current.Stage
+        }
+        // 選考状況は送られた値（空文字も含む）をそのまま保存する
+        selection := body.Selection
+        if err := updateInternship(
+            db,
+            id,
+            userID,
+            body.Title,
+            body.Company,
+            *body.Dailystart,
+            *body.Dailyfinish,
+            body.Content,
+            selection,
+            stage,
+            body.Joined,
+            body.StartsOn,
+            body.EndsOn,
+        ); err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                c.JSON(http.StatusNotFound, gin.H{"error": "internship not found"})
+                return
+            }
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        in, err := getInternship(db, id, userID)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, in)
+    }
+}
+
+
+// Internship の PATCH で更新できる項目（stage・selection は別に扱う）
+var internshipPatchFields = map[string]patchField{
+	"title":       {Column: "title", Kind: patchString, NotEmpty: true},
+	"company":     {Column: "company", Kind: patchString, NotEmpty: true},
+	"dailystart":  {Column: "dailystart", Kind: patchInt},
+	"dailyfinish": {Column: "dailyfinish", Kind: patchInt},
+	"content":     {Column: "content", Kind: patchString},
+	"joined":      {Column: "joined", Kind: patchBool},
+	"starts_on":   {Column: "starts_on", Kind: patchString},
+	"ends_on":     {Column: "ends_on", Kind: patchString},
+}
+
+// patchInternshipHandler は Internship を JSON Merge Patch で部分更新するハンドラ
+// 含まれない項目は変更せず、明示された false・0・空文字は更新します
+func patchInternshipHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		patch, err := readMergePatch(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sp, err := takeStagePatch(patch)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if sp.HasChangedAt || sp.Note != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "stage_changed_at and stage_note are only supported for company lists"})
+			return
+		}
+		updates, err := patchUpdates(patch, internshipPatchFields)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		current, err := getInternship(db, id, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "internship not found"})
+			return
+		}
+		if err := sp.apply(db, userID, current.Stage, updates); err != nil {
+			respondStageError(c, err)
+			return
+		}
+		// 実施期間は更新後の開始日・終了日の組み合わせで確認する
+		startsOn, endsOn := current.StartsOn, current.EndsOn
+		if v, ok := updates["starts_on"]; ok {
+			startsOn = v.(string)
+		}
+		if v, ok := updates["ends_on"]; ok {
+			endsOn = v.(string)
+		}
+		if err := validateInternshipPeriod(startsOn, endsOn); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		in, err := patchInternship(db, id, userID, updates)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "internship not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, in)
+	}
+}
+
+//インターンシップ一覧handler処理
+// stage・joined・company（部分一致）・created/updated の範囲で絞り込み、
+// sort で並び替え、limit と cursor でページングします
+func listInternshipsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")  // コンテキストからuserIDを取得
+		q, err := parseInternshipQuery(c, db, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		list, total, more, err := queryInternships(db, userID, q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var next string
+		if more {
+			next = q.Cursor(list[len(list)-1].sortValues(q.Sort))
+		}
+		setListHeaders(c, total, next)
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// parseInternshipQuery は Internship 一覧のクエリパラメータを読み取ります
+func parseInternshipQuery(c *gin.Context, db *gorm.DB, userID uint) (ListQuery, error) {
+	q, err := parseListQuery(c, internshipSortFields)
+	if err != nil {
+		return q, err
+	}
+	if err := parseStageFilter(c, db, &q, userID); err != nil {
+		return q, err
+	}
+	if err := parseBoolFilter(c, &q, "joined", "joined"); err != nil {
+		return q, err
+	}
+	parseContainsFilter(c, &q, "company", "company")
+	if err := parseTimeRangeFilter(c, &q, "created", "created_at"); err != nil {
+		return q, err
+	}
+	if err := parseTimeRangeFilter(c, &q, "updated", "updated_at"); err != nil {
+		return q, err
+	}
+	if err := parseTagFilter(c, db, &q, userID, "internship_tags", "internship_id"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+//インターンシップ削除handler処理
+func deleteInternshipHandler(db *gorm.DB) gin.HandlerFunc{
+	return func(c *gin.Context){
+		userID := c.GetUint("userID")
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		//repositoryのdeleteInternshipを呼び出し処理
+		in, err := deleteInternship(db, id, userID)
+		if err != nil{
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "internship not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, in)
+	}
+}
+
+// companyTimelineHandler は CompanyList の選考ステージの履歴を返すハンドラを返します
+// 各項目の duration_seconds は次の変更まで（最新の項目は選考中なら現在まで）の所要時間です
+func companyTimelineHandler(db *gorm.DB) gin.HandlerFunc {
+	type TimelineEntry struct {
+		StageChange
+		FromLabel       string `json:"from_label"`
+		ToLabel         string `json:"to_label"`
+		DurationSeconds *int64 `json:"duration_seconds"`
+	}
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		if _, err := getCompanyList(db, id, userID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+			return
+		}
+		changes, err := listStageChanges(db, id, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		label := func(stage string) string {
+			if stage == "" {
+				return ""
+			}
+			info, err := lookupStage(db, userID, stage)
+			if err != nil {
+				return stage
+			}
+			return info.Label
+		}
+		entries := make([]TimelineEntry, 0, len(changes))
+		for i, sc := range changes {
+			e := TimelineEntry{StageChange: sc, FromLabel: label(sc.FromStage), ToLabel: label(sc.ToStage)}
+			var end time.Time
+			if i+1 < len(changes) {
+				end = changes[i+1].ChangedAt
+			} else if !isTerminalStage(sc.ToStage) {
+				end = time.Now()
+			}
+			if !end.IsZero() {
+				d := int64(end.Sub(sc.ChangedAt).Seconds())
+				e.DurationSeconds = &d
+			}
+			entries = append(entries, e)
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// updateTimelineEntryHandler はタイムラインの項目の日時（後からの記録）とメモを修正するハンドラを返します
+func updateTimelineEntryHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		ChangedAt *string `json:"changed_at"`
+		Note      *string `json:"note" binding:"omitempty,max=1000"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var changedAt *time.Time
+		if body.ChangedAt != nil {
+			t, err := parseStageChangedAt(*body.ChangedAt)
+			if err != nil || *body.ChangedAt == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "changed_at must be a past RFC 3339 timestamp"})
+				return
+			}
+			changedAt = &t
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		entryID, ok := parseIDParam(c, "entryId")
+		if !ok {
+			return
+		}
+		sc, err := updateStageChange(db, entryID, id, c.GetUint("userID"), changedAt, body.Note)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "timeline entry not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, sc)
+	}
+}
+
+// 選考ステージ関連のハンドラー
+
+// StageResponse は選考ステージ一覧の 1 件です
+type StageResponse struct {
+	Key      string `json:"key"`
+	Label    string `json:"label"`
+	Terminal bool   `json:"terminal"`        // 選考が終わったステージ
+	ID       uint   `json:"id,omitempty"`    // 独自ステージのみ
+	After    string `json:"after,omitempty"` // 独自ステージのみ
+}
+
+// 選考ステージ一覧ハンドラー（組み込みと独自ステージを選考順に返す）
+func listSelectionStagesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		custom, err := listSelectionStages(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var keys []string
+		for _, s := range builtinStages {
+			keys = append(keys, s.Key)
+			if s.Key == StageGroupDiscussion {
+				for n := 1; n <= maxInterviewRound; n++ {
+					keys = append(keys, interviewStage(n))
+				}
+			}
+		}
+		stages := make([]StageResponse, 0, len(keys)+len(custom))
+		for _, key := range keys {
+			stages = append(stages, StageResponse{Key: key, Label: builtinStageLabel(key), Terminal: isTerminalStage(key)})
+			for _, cs := range custom {
+				if cs.After == key {
+					stages = append(stages, StageResponse{Key: cs.Key(), Label: cs.Name, ID: cs.ID, After: cs.After})
+				}
+			}
+		}
+		c.JSON(http.StatusOK, stages)
+	}
+}
+
+// 独自ステージ作成ハンドラー（after に指定した組み込みステージの直後に並ぶ）
+func createSelectionStageHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Name  string `json:"name" binding:"required,max=50"`
+		After string `json:"after" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, ok := builtinStageOrder(body.After); !ok || isTerminalStage(body.After) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "after must be a built-in stage that is not final", "field": "after"})
+			return
+		}
+		s := &SelectionStage{UserID: c.GetUint("userID"), Name: body.Name, After: body.After}
+		if err := createSelectionStage(db, s); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, StageResponse{Key: s.Key(), Label: s.Name, ID: s.ID, After: s.After})
+	}
+}
+
+// 独自ステージ削除ハンドラー（使用中の場合は 409）
+func deleteSelectionStageHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		s, err := deleteSelectionStage(db, id, c.GetUint("userID"))
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "stage not found"})
+			case errors.Is(err, errStageInUse):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		c.JSON(http.StatusOK, StageResponse{Key: s.Key(), Label: s.Name, ID: s.ID, After: s.After})
+	}
+}
+
+// ステージごとの件数の集計ハンドラー（CompanyList とインターンシップ）
+func stageSummaryHandler(db *gorm.DB) gin.HandlerFunc {
+	type SummaryRow struct {
+		Stage        string `json:"stage"`
+		Label        string `json:"label"`
+		CompanyLists int64  `json:"company_lists"`
+		Internships  int64  `json:"internships"`
+	}
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		companies, err := countByStage(db, &CompanyList{}, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		internships, err := countByStage(db, &Internship{}, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		rows := map[string]*SummaryRow{}
+		var infos []stageInfo
+		row := func(stage string) *SummaryRow {
+			if r, ok := rows[stage]; ok {
+				return r
+			}
+			info := stageInfo{Key: stage, Label: "未設定", Order: -1}
+			if stage != "" {
+				var err error
+				if info, err = lookupStage(db, userID, stage); err != nil {
+					info = stageInfo{Key: stage, Label: stage, Order: 1 << 30}
+				}
+			}
+			infos = append(infos, info)
+			rows[stage] = &SummaryRow{Stage: stage, Label: info.Label}
+			return rows[stage]
+		}
+		for _, sc := range companies {
+			row(sc.Stage).CompanyLists = sc.Count
+		}
+		for _, sc := range internships {
+			row(sc.Stage).Internships = sc.Count
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].before(infos[j]) })
+		summary := make([]SummaryRow, 0, len(infos))
+		for _, info := range infos {
+			summary = append(summary, *rows[info.Key])
+		}
+		c.JSON(http.StatusOK, summary)
+	}
+}
+
+// 掲示板関連のハンドラー
+
+// 投稿作成ハンドラー
+func createPostHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Title       string `json:"title" binding:"required"`
+		Content     string `json:"content" binding:"required"`
+		DisplayName string `json:"display_name" binding:"required,max=20"`
+	}
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		
+		post := &Post{
+			Title:       body.Title,
+			Content:     body.Content,
+			DisplayName: body.DisplayName,
+			UserID:      userID,
+		}
+		
+		if err := createPost(db, post); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		
+		// デバッグ用ログ
+		log.Printf("Created post: %+v", post)
+		
+		c.JSON(http.StatusCreated, post)
+	}
+}
+
+// 投稿一覧取得ハンドラー
+func getPostsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// ページネーションパラメータ
+		var limit = 20
+		var offset = 0
+		
+		if l := c.Query("limit"); l != "" {
+			fmt.Sscanf(l, "%d", &limit)
+		}
+		if o := c.Query("offset"); o != "" {
+			fmt.Sscanf(o, "%d", &offset)
+		}
+		
+		posts, err := getPosts(db, limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		
+		// 各投稿に対していいね状態を追加
+		userID := c.GetUint("userID")
+		type PostResponse struct {
+			ID           uint   `json:"ID"`
+			Title        string `json:"title"`
+			Content      string `json:"content"`
+			DisplayName  string `json:"display_name"`
+			LikeCount    int    `json:"like_count"`
+			CommentCount int    `json:"comment_count"`
+			UserID       uint   `json:"user_id"`
+			CreatedAt    time.Time `json:"CreatedAt"`
+			IsLiked      bool   `json:"is_liked"`
+		}
+		
+		var response []PostResponse
+		for _, post := range posts {
+			isLiked, _ := checkUserLiked(db, post.ID, userID)
+			response = append(response, PostResponse{
+				ID:           post.ID,
+				Title:        post.Title,
+				Content:      post.Content,
+				DisplayName:  post.DisplayName,
+				LikeCount:    post.LikeCount,
+				CommentCount: post.CommentCount,
+				UserID:       post.UserID,
+				CreatedAt:    post.CreatedAt,
+				IsLiked:      isLiked,
+			})
+		}
+		
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// 投稿詳細取得ハンドラー
+func getPostHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		
+		post, err := getPost(db, postID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		
+		// いいね状態を確認
+		userID := c.GetUint("userID")
+		isLiked, _ := checkUserLiked(db, postID, userID)
+		
+		// コメント一覧を取得
+		comments, _ := getComments(db, postID)
+		
+		c.JSON(http.StatusOK, gin.H{
+			"post":     post,
+			"is_liked": isLiked,
+			"comments": comments,
+		})
+	}
+}
+
+// 投稿削除ハンドラー
+func deletePostHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		postID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		
+		post, err := deletePost(db, postID, userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, post)
+	}
+}
+
+// いいね追加ハンドラー
+func likePostHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		postID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		if _, err := getPost(db, postID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		
+		like := &Like{
+			PostID: postID,
+			UserID: userID,
+		}
+		
+		if err := createLike(db, like); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		respondPost(c, db, http.StatusCreated, postID)
+	}
+}
+
+// いいね削除ハンドラー
+func unlikePostHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		postID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		
+		if err := deleteLike(db, postID, userID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Like not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		respondPost(c, db, http.StatusOK, postID)
+	}
+}
+
+// コメント作成ハンドラー
+func createCommentHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Content     string `json:"content" binding:"required"`
+		DisplayName string `json:"display_name" binding:"required,max=20"`
+	}
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		postID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		
+		comment := &Comment{
+			Content:     body.Content,
+			DisplayName: body.DisplayName,
+			PostID:      postID,
+			UserID:      userID,
+		}
+		
+		if _, err := getPost(db, postID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		if err := createComment(db, comment); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, comment)
+	}
+}
+
+// respondPost は更新後の投稿（いいね数・コメント数を含む）を返します
+func respondPost(c *gin.Context, db *gorm.DB, status int, postID uint) {
+	post, err := getPost(db, postID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, post)
+}
+
+// 管理者用のハンドラー
+
+// ログイン失敗記録一覧ハンドラー
+// kind（email / ip）と locked=true で絞り込めます
+func listLoginAttemptsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var limit = 50
+		var offset = 0
+		if l := c.Query("limit"); l != "" {
+			fmt.Sscanf(l, "%d", &limit)
+		}
+		if o := c.Query("offset"); o != "" {
+			fmt.Sscanf(o, "%d", &offset)
+		}
+		attempts, err := listLoginAttempts(db, c.Query("kind"), c.Query("locked") == "true", time.Now(), limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, attempts)
+	}
+}
+
+// ログイン失敗記録の削除（ロック解除）ハンドラー
+func deleteLoginAttemptHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		a, err := deleteLoginAttempt(db, id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "login attempt not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, a)
+	}
+}
+
+// parseAuditEventFilter は監査ログの検索条件をクエリパラメータから読み取ります
+// type はカンマ区切りで複数指定でき、from / to は RFC 3339 形式です
+func parseAuditEventFilter(c *gin.Context) (AuditEventFilter, error) {
+	f := AuditEventFilter{Limit: 50, Result: c.Query("result")}
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 200 {
+			return f, errors.New("limit must be between 1 and 200")
+		}
+		f.Limit = n
+	}
+	if o := c.Query("offset"); o != "" {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 {
+			return f, errors.New("offset must be a non-negative integer")
+		}
+		f.Offset = n
+	}
+	if t := c.Query("type"); t != "" {
+		for _, typ := range strings.Split(t, ",") {
+			if typ = strings.TrimSpace(typ); typ != "" {
+				f.Types = append(f.Types, typ)
+			}
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 timestamp", p.name)
+			}
+			*p.dst = &t
+		}
+	}
+	return f, nil
+}
+
+// mySecurityEventsHandler は自分のアカウントの監査ログを返すハンドラを返します
+func mySecurityEventsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, err := parseAuditEventFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		f.UserID = c.GetUint("userID")
+		events, err := listAuditEvents(db, f)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, events)
+	}
+}
+
+// 監査ログ検索ハンドラー（管理者用、user_id で対象ユーザーを絞り込む）
+func listAuditEventsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, err := parseAuditEventFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if u := c.Query("user_id"); u != "" {
+			id, err := strconv.ParseUint(u, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+				return
+			}
+			f.UserID = uint(id)
+		}
+		events, err := listAuditEvents(db, f)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, events)
+	}
+}
+
+// ユーザー権限変更ハンドラー
+// 変更後は対象ユーザーのトークンを失効させ、新しい権限を即座に反映します
+func updateUserRoleHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !isValidRole(body.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be one of user, moderator, admin"})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		// 自分自身の降格で管理者がいなくなるのを防ぐ
+		if id == c.GetUint("userID") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot change your own role"})
+			return
+		}
+		if err := updateUserRole(db, id, body.Role); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := revokeAllUserTokens(db, id); err != nil {
+			log.Printf("[admin] revokeAllUserTokens error: %v", err)
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: id, ActorID: c.GetUint("userID"), Type: AuditRoleChange, Result: AuditResultSuccess, Detail: "role " + body.Role})
+		c.JSON(http.StatusOK, gin.H{"user_id": id, "role": body.Role})
+	}
+}
+
+// モデレーターによる投稿削除ハンドラー
+func moderatorDeletePostHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		post, err := deletePostByModerator(db, postID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, post)
+	}
+}
+
+// 個人アクセストークン関連のハンドラー
+
+// 個人アクセストークン作成ハンドラー
+// トークン本体はこのレスポンスでのみ返します
+func createPersonalAccessTokenHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Name          string   `json:"name" binding:"required,max=100"`
+		Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=read write"`
+		ExpiresInDays int      `json:"expires_in_days" binding:"required,min=1,max=365"`
+	}
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		secret, err := GenerateOpaqueToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		token := personalAccessTokenPrefix + secret
+		pat := &PersonalAccessToken{
+			UserID:    userID,
+			Name:      body.Name,
+			TokenHash: HashToken(token),
+			Prefix:    token[:len(personalAccessTokenPrefix)+6],
+			Scopes:    strings.Join(body.Scopes, ","),
+			ExpiresAt: time.Now().AddDate(0, 0, body.ExpiresInDays),
+		}
+		if err := createPersonalAccessToken(db, pat); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: userID, Type: AuditTokenCreate, Result: AuditResultSuccess, Detail: fmt.Sprintf("personal access token %d", pat.ID)})
+		c.JSON(http.StatusCreated, gin.H{"token": token, "personal_access_token": pat})
+	}
+}
+
+// 個人アクセストークン一覧ハンドラー
+func listPersonalAccessTokensHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokens, err := listPersonalAccessTokens(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// 個人アクセストークン失効ハンドラー
+func revokePersonalAccessTokenHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		pat, err := revokePersonalAccessToken(db, id, c.GetUint("userID"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAuditEvent(c, db, AuditEvent{UserID: c.GetUint("userID"), Type: AuditTokenRevoke, Result: AuditResultSuccess, Detail: fmt.Sprintf("personal access token %d", id)})
+		c.JSON(http.StatusOK, pat)
+	}
+}
+
+// タグ関連のハンドラー
+
+// tagColorDefault はタグ作成時に色を省略した場合の色です
+const tagColorDefault = "#9e9e9e"
+
+// タグ一覧ハンドラー
+func listTagsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tags, err := listTags(db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, tags)
+	}
+}
+
+// タグ作成ハンドラー（同じ名前のタグがあれば 409）
+func createTagHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Name  string `json:"name" binding:"required,max=50"`
+		Color string `json:"color" binding:"omitempty,hexcolor"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name := strings.TrimSpace(body.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+			return
+		}
+		color := strings.ToLower(body.Color)
+		if color == "" {
+			color = tagColorDefault
+		}
+		t := &Tag{UserID: c.GetUint("userID"), Name: name, Color: color}
+		if err := createTag(db, t); err != nil {
+			if errors.Is(err, errTagNameTaken) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, t)
+	}
+}
+
+// タグの名前・色の変更ハンドラー（付けてあるすべてのレコードに反映される）
+func updateTagHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Name  string `json:"name" binding:"required,max=50"`
+		Color string `json:"color" binding:"required,hexcolor"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name := strings.TrimSpace(body.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		t, err := updateTag(db, id, c.GetUint("userID"), name, strings.ToLower(body.Color))
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+			case errors.Is(err, errTagNameTaken):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	}
+}
+
+// タグ削除ハンドラー（付けてあるレコードからも外す）
+func deleteTagHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		t, err := deleteTag(db, id, c.GetUint("userID"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	}
+}
+
+// タグ統合ハンドラー（:id のタグを into のタグにまとめ、:id のタグは削除する）
+func mergeTagHandler(db *gorm.DB) gin.HandlerFunc {
+	type req struct {
+		Into uint `json:"into" binding:"required"`
+	}
+	return func(c *gin.Context) {
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		if id == body.Into {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot merge a tag into itself"})
+			return
+		}
+		t, err := mergeTags(db, id, body.Into, c.GetUint("userID"))
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+			case errors.Is(err, errUnknownTag):
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "merge target not found", "field": "into"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	}
+}
+
+// tagIDsRequest はレコードに付けるタグの指定です（空の配列ですべて外す）
+type tagIDsRequest struct {
+	TagIDs []uint `json:"tag_ids" binding:"required"`
+}
+
+// CompanyList のタグ設定ハンドラー（指定したタグに置き換える）
+func setCompanyListTagsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		var body tagIDsRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		cl, err := getCompanyList(db, id, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+			return
+		}
+		tags, err := getTagsByIDs(db, userID, body.TagIDs)
+		if err != nil {
+			respondTagError(c, err)
+			return
+		}
+		if err := setCompanyListTags(db, cl, tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if cl, err = getCompanyList(db, id, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cl)
+	}
+}
+
+// Internship のタグ設定ハンドラー（指定したタグに置き換える）
+func setInternshipTagsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		var body tagIDsRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		in, err := getInternship(db, id, userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "internship not found"})
+			return
+		}
+		tags, err := getTagsByIDs(db, userID, body.TagIDs)
+		if err != nil {
+			respondTagError(c, err)
+			return
+		}
+		if err := setInternshipTags(db, in, tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if in, err = getInternship(db, id, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, in)
+	}
+}
+
+// respondTagError は存在しないタグの指定を 422 で返します
+func respondTagError(c *gin.Context, err error) {
+	if errors.Is(err, errUnknownTag) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "field": "tag_ids"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// ノート関連のハンドラー
+
+// ノートの入力
+type noteRequest struct {
+	Type  string `json:"type" binding:"required,oneof=research interview ob_visit"`
+	Title string `json:"title" binding:"max=200"`
+	Body  string `json:"body" binding:"required,max=50000"`
+	Stage string `json:"stage"`
+}
+
+// ownedCompanyListID は :id の CompanyList が自分のものか確認します（なければ 404 を返し ok=false）
+func ownedCompanyListID(c *gin.Context, db *gorm.DB) (uint, bool) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return 0, false
+	}
+	if _, err := getCompanyList(db, id, c.GetUint("userID")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "company list not found"})
+		return 0, false
+	}
+	return id, true
+}
+
+// validateNoteStage は関連付けるステージが存在するか確認します（空は関連付けなし）
+func validateNoteStage(db *gorm.DB, userID uint, stage string) error {
+	if stage == "" {
+		return nil
+	}
+	_, err := lookupStage(db, userID, stage)
+	return err
+}
+
+// CompanyList のノート一覧ハンドラー（type・stage で絞り込み、sort・limit・cursor に対応）
+func listNotesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		companyListID, ok := ownedCompanyListID(c, db)
+		if !ok {
+			return
+		}
+		q, err := parseNoteQuery(c, db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		q.Where("company_list_id = ?", companyListID)
+		respondNotes(c, db, q, false)
+	}
+}
+
+// ノート検索ハンドラー（全 CompanyList のノートをタイトル・本文で検索）
+// q は空白区切りで、すべての語を含むノートを返します
+func searchNotesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, err := parseNoteQuery(c, db, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondNotes(c, db, q, true)
+	}
+}
+
+// parseNoteQuery はノート一覧・検索のクエリパラメータを読み取ります
+func parseNoteQuery(c *gin.Context, db *gorm.DB, userID uint) (ListQuery, error) {
+	q, err := parseListQuery(c, noteSortFields)
+	if err != nil {
+		return q, err
+	}
+	if t := c.Query("type"); t != "" {
+		if t != NoteTypeResearch && t != NoteTypeInterview && t != NoteTypeOBVisit {
+			return q, errors.New("type must be one of research, interview, ob_visit")
+		}
+		q.Where("type = ?", t)
+	}
+	if err := parseStageFilter(c, db, &q, userID); err != nil {
+		return q, err
+	}
+	parseSearchFilter(c, &q, "title", "body")
+	return q, nil
+}
+
+// respondNotes はノートの 1 ページを返します（withCompany の場合は企業名を付ける）
+func respondNotes(c *gin.Context, db *gorm.DB, q ListQuery, withCompany bool) {
+	type NoteResult struct {
+		Note
+		Company string `json:"company"`
+	}
+	userID := c.GetUint("userID")
+	notes, total, more, err := queryNotes(db, userID, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var next string
+	if more {
+		next = q.Cursor(notes[len(notes)-1].sortValues(q.Sort))
+	}
+	setListHeaders(c, total, next)
+	if !withCompany {
+		c.JSON(http.StatusOK, notes)
+		return
+	}
+	ids := make([]uint, 0, len(notes))
+	for _, n := range notes {
+		ids = append(ids, n.CompanyListID)
+	}
+	names, err := companyNamesByIDs(db, userID, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	results := make([]NoteResult, 0, len(notes))
+	for _, n := range notes {
+		results = append(results, NoteResult{Note: n, Company: names[n.CompanyListID]})
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// ノート作成ハンドラー
+func createNoteHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		var body noteRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		companyListID, ok := ownedCompanyListID(c, db)
+		if !ok {
+			return
+		}
+		if err := validateNoteStage(db, userID, body.Stage); err != nil {
+			respondStageError(c, err)
+			return
+		}
+		n := &Note{
+			UserID:        userID,
+			CompanyListID: companyListID,
+			Type:          body.Type,
+			Title:         body.Title,
+			Body:          body.Body,
+			Stage:         body.Stage,
+		}
+		if err := createNote(db, n); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, n)
+	}
+}
+
+// ノート取得ハンドラー
+func getNoteHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		companyListID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		noteID, ok := parseIDParam(c, "noteId")
+		if !ok {
+			return
+		}
+		n, err := getNote(db, noteID, companyListID, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+			return
+		}
+		c.JSON(http.StatusOK, n)
+	}
+}
+
+// ノート更新ハンドラー
+func updateNoteHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("userID")
+		var body noteRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		companyListID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		noteID, ok := parseIDParam(c, "noteId")
+		if !ok {
+			return
+		}
+		if err := validateNoteStage(db, userID, body.Stage); err != nil {
+			respondStageError(c, err)
+			return
+		}
+		n, err := updateNote(db, noteID, companyListID, userID, body.Type, body.Title, body.Body, body.Stage)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, n)
+	}
+}
+
+// ノート削除ハンドラー
+func deleteNoteHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		companyListID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		noteID, ok := parseIDParam(c, "noteId")
+		if !ok {
+			return
+		}
+		n, err := deleteNote(db, noteID, companyListID, c.GetUint("userID"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "note not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, n)
+	}
+}
+
+// 連絡先関連のハンドラー
+
+// 連絡先の入力
+type contactRequest struct {
+	Name            string     `json:"name" binding:"required,max=100"`
+	Role            string     `json:"role" binding:"max=100"`
+	Email           string     `json:"email" binding:"omitempty,email,max=255"`
+	Phone           string     `json:"phone" binding:"max=50"`
+	LastContactedAt *time.Time `json:"last_contacted_at"`
+	Notes           string     `json:"notes" binding:"max=10000"`
+}
+
+// contact はリクエストの内容を Contact にします
+// 最終連絡日時は UTC にそろえて保存します（SQLite は日時を文字列として比べるため）
+// やり取りと同じく、未来の最終連絡日時は受け付けません
+func (r contactRequest) contact() (*Contact, error) {
+	if r.LastContactedAt != nil && r.LastContactedAt.After(time.Now()) {
+		return nil, errors.New("last_contacted_at must not be in the future")
+	}
+	ct := &Contact{
+		Name:  r.Name,
+		Role:  r.Role,
+		Email: r.Email,
+		Phone: r.Phone,
+		Notes: r.Notes,
+	}
+	if r.LastContactedAt != nil {
+		t := r.LastContactedAt.UTC()
+		ct.LastContactedAt = &t
+	}
+	return ct, nil
+}
+
+// contactFromPath は :id と :contactId の連絡先を取得します（なければ 404 を返し ok=false）
+func contactFromPath(c *gin.Context, db *gorm.DB) (*Contact, bool) {
+	companyListID, ok := parseIDParam(c, "id")
+	if !ok {
+		return nil, false
+	}
+	contactID, ok := parseIDParam(c, "contactId")
+	if !ok {
+		return nil, false
+	}
+	ct, err := getContact(db, contactID, companyListID, c.GetUint("userID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "contact not found"})
+		return nil, false
+	}
+	return ct, true
+}
+
+// CompanyList の連絡先一覧ハンドラー
+func listContactsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		companyListID, ok := ownedCompanyListID(c, db)
+		if !ok {
+			return
+		}
+		contacts, err := listContacts(db, companyListID, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, contacts)
+	}
+}
+
+// 連絡先作成ハンドラー
+func createContactHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body contactRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		companyListID, ok := ownedCompanyListID(c, db)
+		if !ok {
+			return
+		}
+		ct, err := body.contact()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ct.UserID = c.GetUint("userID")
+		ct.CompanyListID = companyListID
+		if err := createContact(db, ct); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, ct)
+	}
+}
+
+// 連絡先取得ハンドラー
+func getContactHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ct, ok := contactFromPath(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, ct)
+	}
+}
+
+// 連絡先更新ハンドラー
+func updateContactHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body contactRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		companyListID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		contactID, ok := parseIDParam(c, "contactId")
+		if !ok {
+			return
+		}
+		ct, err := body.contact()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ct, err = updateContact(db, contactID, companyListID, c.GetUint("userID"), ct)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "contact not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ct)
 	}
-    
-    return func(c *gin.Context) {
-        userID := c.GetUint("userID")
-        var body req
-        if err := c.ShouldBindJSON(&body); err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-            return
-        }
-        var id uint
-        fmt.Sscanf(c.Param("id"), "%d", &id)
-        if err := updateInternship(
-            db,
-            id,
-            userID,
-            body.Title,
-            body.Company,
-            body.Dailystart,
-            body.Dailyfinish,
-            body.Content,
-            body.Selection,
-            body.Joined,
-        ); err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-        c.Status(http.StatusNoContent)
-    }
 }
 
-
-//インターンシップ一覧handler処理
-func listInternshipsHandler(db *gorm.DB) gin.HandlerFunc {
+// 連絡先削除ハンドラー（やり取りの記録も削除）
+func deleteContactHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetUint("userID")  // コンテキストからuserIDを取得
-		list, err := listInternships(db, userID)
+		companyListID, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		contactID, ok := parseIDParam(c, "contactId")
+		if !ok {
+			return
+		}
+		ct, err := deleteContact(db, contactID, companyListID, c.GetUint("userID"))
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "contact not found"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, list)
+		c.JSON(http.StatusOK, ct)
 	}
 }
 
-//インターンシップ削除handler処理
-func deleteInternshipHandler(db *gorm.DB) gin.HandlerFunc{
-	return func(c *gin.Context){
-		userID := c.GetUint("userID")
-		var id uint
-		fmt.Sscanf(c.Param("id"), "%d", &id)
-		//repositoryのdeleteInternshipを呼び出し処理
-		if err := deleteInternship(db, id, userID); err != nil{
+// やり取り一覧ハンドラー（新しい順）
+func listContactInteractionsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ct, ok := contactFromPath(c, db)
+		if !ok {
+			return
+		}
+		interactions, err := listContactInteractions(db, ct.ID, ct.UserID)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.Status(http.StatusNoContent)
+		c.JSON(http.StatusOK, interactions)
 	}
 }
 
-// 掲示板関連のハンドラー
-
-// 投稿作成ハンドラー
-func createPostHandler(db *gorm.DB) gin.HandlerFunc {
+// やり取り記録ハンドラー
+// occurred_at を省略すると現在時刻。未来の日時は指定できません
+func createContactInteractionHandler(db *gorm.DB) gin.HandlerFunc {
 	type req struct {
-		Title       string `json:"title" binding:"required"`
-		Content     string `json:"content" binding:"required"`
-		DisplayName string `json:"display_name" binding:"required,max=20"`
+		Type       string     `json:"type" binding:"required,oneof=email call event"`
+		OccurredAt *time.Time `json:"occurred_at"`
+		Summary    string     `json:"summary" binding:"max=10000"`
 	}
 	return func(c *gin.Context) {
-		userID := c.GetUint("userID")
 		var body req
 		if err := c.ShouldBindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		
-		post := &Post{
-			Title:       body.Title,
-			Content:     body.Content,
-			DisplayName: body.DisplayName,
-			UserID:      userID,
+		occurredAt := time.Now()
+		if body.OccurredAt != nil {
+			if body.OccurredAt.After(occurredAt) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "occurred_at must not be in the future"})
+				return
+			}
+			occurredAt = *body.OccurredAt
 		}
-		
-		if err := createPost(db, post); err != nil {
+		ct, ok := contactFromPath(c, db)
+		if !ok {
+			return
+		}
+		in := &ContactInteraction{
+			UserID:     ct.UserID,
+			ContactID:  ct.ID,
+			Type:       body.Type,
+			OccurredAt: occurredAt.UTC(), // 最終連絡日時と比べるため UTC で保存する
+			Summary:    body.Summary,
+		}
+		if err := createContactInteraction(db, in); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		
-		// デバッグ用ログ
-		log.Printf("Created post: %+v", post)
-		
-		c.JSON(http.StatusCreated, post)
+		c.JSON(http.StatusCreated, in)
 	}
 }
 
-// 投稿一覧取得ハンドラー
-func getPostsHandler(db *gorm.DB) gin.HandlerFunc {
+// やり取り削除ハンドラー
+func deleteContactInteractionHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// ページネーションパラメータ
-		var limit = 20
-		var offset = 0
-		
-		if l := c.Query("limit"); l != "" {
-			fmt.Sscanf(l, "%d", &limit)
+		ct, ok := contactFromPath(c, db)
+		if !ok {
+			return
 		}
-		if o := c.Query("offset"); o != "" {
-			fmt.Sscanf(o, "%d", &offset)
+		interactionID, ok := parseIDParam(c, "interactionId")
+		if !ok {
+			return
 		}
-		
-		posts, err := getPosts(db, limit, offset)
+		in, err := deleteContactInteraction(db, interactionID, ct.ID, ct.UserID)
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		
-		// 各投稿に対していいね状態を追加
+		c.JSON(http.StatusOK, in)
+	}
+}
+
+// フォローアップが必要な連絡先の一覧ハンドラー
+// days（省略時は CONTACT_FOLLOW_UP_DAYS）日以上連絡していない連絡先を、最終連絡が古い順に返します
+func dueContactsHandler(db *gorm.DB, followUpDays int) gin.HandlerFunc {
+	type DueContact struct {
+		Contact
+		Company          string `json:"company"`
+		DaysSinceContact int    `json:"days_since_contact"` // 未連絡の場合は登録からの日数
+	}
+	return func(c *gin.Context) {
 		userID := c.GetUint("userID")
-		type PostResponse struct {
-			ID           uint   `json:"ID"`
-			Title        string `json:"title"`
-			Content      string `json:"content"`
-			DisplayName  string `json:"display_name"`
-			LikeCount    int    `json:"like_count"`
-			CommentCount int    `json:"comment_count"`
-			UserID       uint   `json:"user_id"`
-			CreatedAt    time.Time `json:"CreatedAt"`
-			IsLiked      bool   `json:"is_liked"`
+		days := followUpDays
+		if v := c.Query("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a positive integer"})
+				return
+			}
+			days = n
 		}
-		
-		var response []PostResponse
-		for _, post := range posts {
-			isLiked, _ := checkUserLiked(db, post.ID, userID)
-			response = append(response, PostResponse{
-				ID:           post.ID,
-				Title:        post.Title,
-				Content:      post.Content,
-				DisplayName:  post.DisplayName,
-				LikeCount:    post.LikeCount,
-				CommentCount: post.CommentCount,
-				UserID:       post.UserID,
-				CreatedAt:    post.CreatedAt,
-				IsLiked:      isLiked,
+		now := time.Now()
+		contacts, err := listDueContacts(db, userID, now.AddDate(0, 0, -days))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		ids := make([]uint, 0, len(contacts))
+		for _, ct := range contacts {
+			ids = append(ids, ct.CompanyListID)
+		}
+		names, err := companyNamesByIDs(db, userID, ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		results := make([]DueContact, 0, len(contacts))
+		for _, ct := range contacts {
+			results = append(results, DueContact{
+				Contact:          ct,
+				Company:          names[ct.CompanyListID],
+				DaysSinceContact: int(now.Sub(ct.lastContactOrCreated()).Hours() / 24),
 			})
 		}
-		
-		c.JSON(http.StatusOK, response)
+		c.JSON(http.StatusOK, results)
 	}
 }
 
-// 投稿詳細取得ハンドラー
-func getPostHandler(db *gorm.DB) gin.HandlerFunc {
+// 予定・カレンダー関連のハンドラー
+
+// 予定の入力
+// starts_at・ends_at はオフセット付き（RFC 3339）か、time_zone の時刻（2006-01-02T15:04）で指定します
+type eventRequest struct {
+	CompanyListID *uint  `json:"company_list_id" binding:"required_without=InternshipID,excluded_with=InternshipID"`
+	InternshipID  *uint  `json:"internship_id"`
+	Type          string `json:"type" binding:"required,oneof=es_deadline web_test interview briefing other"`
+	Title         string `json:"title" binding:"required,max=200"`
+	StartsAt      string `json:"starts_at" binding:"required"`
+	EndsAt        string `json:"ends_at"`
+	TimeZone      string `json:"time_zone"` // IANA のタイムゾーン名（省略時は Asia/Tokyo）
+	Location      string `json:"location" binding:"max=255"`
+	OnlineURL     string `json:"online_url" binding:"omitempty,url,max=2048"`
+	Reminders     []int  `json:"reminders" binding:"max=5,dive,min=1,max=40320"` // 開始の何分前に通知するか
+}
+
+// event はリクエストの内容を検証して Event にします
+// 紐づけ先が自分のものでなければ errUnknownEventTarget を返します
+func (r eventRequest) event(db *gorm.DB, userID uint) (*Event, error) {
+	loc, err := loadTimeZone(r.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	startsAt, err := parseEventTime("starts_at", r.StartsAt, loc)
+	if err != nil {
+		return nil, err
+	}
+	var endsAt *time.Time
+	if r.EndsAt != "" {
+		t, err := parseEventTime("ends_at", r.EndsAt, loc)
+		if err != nil {
+			return nil, err
+		}
+		if t.Before(startsAt) {
+			return nil, errors.New("ends_at must not be before starts_at")
+		}
+		t = t.UTC()
+		endsAt = &t
+	}
+	if r.CompanyListID != nil {
+		if _, err := getCompanyList(db, *r.CompanyListID, userID); err != nil {
+			return nil, errUnknownEventTarget
+		}
+	}
+	if r.InternshipID != nil {
+		if _, err := getInternship(db, *r.InternshipID, userID); err != nil {
+			return nil, errUnknownEventTarget
+		}
+	}
+	return &Event{
+		UserID:        userID,
+		CompanyListID: r.CompanyListID,
+		InternshipID:  r.InternshipID,
+		Type:          r.Type,
+		Title:         r.Title,
+		StartsAt:      startsAt.UTC(),
+		EndsAt:        endsAt,
+		TimeZone:      loc.String(),
+		Location:      r.Location,
+		OnlineURL:     r.OnlineURL,
+	}, nil
+}
+
+// respondEventError は予定の入力エラーを返します（紐づけ先がなければ 422）
+func respondEventError(c *gin.Context, err error, body eventRequest) {
+	if errors.Is(err, errUnknownEventTarget) {
+		field := "company_list_id"
+		if body.InternshipID != nil {
+			field = "internship_id"
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "field": field})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// 予定一覧ハンドラー
+// type・company_list_id・internship_id・starts_from / starts_to で絞り込み、sort・limit・cursor に対応します
+func listEventsHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var postID uint
-		fmt.Sscanf(c.Param("id"), "%d", &postID)
-		
-		post, err := getPost(db, postID)
+		q, err := parseListQuery(c, eventSortFields)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		
-		// いいね状態を確認
-		userID := c.GetUint("userID")
-		isLiked, _ := checkUserLiked(db, postID, userID)
-		
-		// コメント一覧を取得
-		comments, _ := getComments(db, postID)
-		
-		c.JSON(http.StatusOK, gin.H{
-			"post":     post,
-			"is_liked": isLiked,
-			"comments": comments,
-		})
+		if t := c.Query("type"); t != "" {
+			q.Where("type IN ?", strings.Split(t, ","))
+		}
+		for _, name := range []string{"company_list_id", "internship_id"} {
+			if v := c.Query(name); v != "" {
+				id, err := strconv.ParseUint(v, 10, 64)
+				if err != nil || id == 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+					return
+				}
+				q.Where(name+" = ?", id)
+			}
+		}
+		if err := parseTimeRangeFilterIn(c, &q, "starts", "starts_at", time.UTC); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		events, total, more, err := queryEvents(db, c.GetUint("userID"), q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var next string
+		if more {
+			next = q.Cursor(events[len(events)-1].sortValues(q.Sort))
+		}
+		setListHeaders(c, total, next)
+		for i := range events {
+			events[i].localize()
+		}
+		c.JSON(http.StatusOK, events)
 	}
 }
 
-// 投稿削除ハンドラー
-func deletePostHandler(db *gorm.DB) gin.HandlerFunc {
+// 予定作成ハンドラー
+func createEventHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.GetUint("userID")
-		var postID uint
-		fmt.Sscanf(c.Param("id"), "%d", &postID)
-		
-		if err := deletePost(db, postID, userID); err != nil {
+		var body eventRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		e, err := body.event(db, userID)
+		if err != nil {
+			respondEventError(c, err, body)
+			return
+		}
+		e.Reminders = newEventReminders(e.StartsAt, reminderMinutes(body.Reminders))
+		if err := createEvent(db, e); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.Status(http.StatusNoContent)
+		e.localize()
+		c.JSON(http.StatusCreated, e)
 	}
 }
 
-// いいね追加ハンドラー
-func likePostHandler(db *gorm.DB) gin.HandlerFunc {
+// 予定取得ハンドラー
+func getEventHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetUint("userID")
-		var postID uint
-		fmt.Sscanf(c.Param("id"), "%d", &postID)
-		
-		like := &Like{
-			PostID: postID,
-			UserID: userID,
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
 		}
-		
-		if err := createLike(db, like); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		e, err := getEvent(db, id, c.GetUint("userID"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "event not found"})
 			return
 		}
-		c.Status(http.StatusCreated)
+		e.localize()
+		c.JSON(http.StatusOK, e)
 	}
 }
 
-// いいね削除ハンドラー
-func unlikePostHandler(db *gorm.DB) gin.HandlerFunc {
+// 予定更新ハンドラー（リマインダーも reminders の内容に置き換える）
+func updateEventHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.GetUint("userID")
-		var postID uint
-		fmt.Sscanf(c.Param("id"), "%d", &postID)
-		
-		if err := deleteLike(db, postID, userID); err != nil {
+		var body eventRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		e, err := body.event(db, userID)
+		if err != nil {
+			respondEventError(c, err, body)
+			return
+		}
+		updated, err := updateEvent(db, id, userID, e, reminderMinutes(body.Reminders))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "event not found"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.Status(http.StatusNoContent)
+		updated.localize()
+		c.JSON(http.StatusOK, updated)
 	}
 }
 
-// コメント作成ハンドラー
-func createCommentHandler(db *gorm.DB) gin.HandlerFunc {
-	type req struct {
-		Content     string `json:"content" binding:"required"`
-		DisplayName string `json:"display_name" binding:"required,max=20"`
+// 予定削除ハンドラー
+func deleteEventHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseIDParam(c, "id")
+		if !ok {
+			return
+		}
+		e, err := deleteEvent(db, id, c.GetUint("userID"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "event not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		e.localize()
+		c.JSON(http.StatusOK, e)
 	}
+}
+
+// カレンダーハンドラー
+// from から to まで（to は含まない）の予定とインターンシップの実施期間を開始時刻順に返します
+// 時刻は tz（省略時は Asia/Tokyo）で返し、時間が重なる面接には conflicts を付けます
+func calendarHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.GetUint("userID")
-		var postID uint
-		fmt.Sscanf(c.Param("id"), "%d", &postID)
-		
-		var body req
-		if err := c.ShouldBindJSON(&body); err != nil {
+		loc, err := loadTimeZone(c.Query("tz"))
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		
-		comment := &Comment{
-			Content:     body.Content,
-			DisplayName: body.DisplayName,
-			PostID:      postID,
-			UserID:      userID,
+		from, err := parseCalendarBound("from", c.Query("from"), loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		
-		if err := createComment(db, comment); err != nil {
+		to, err := parseCalendarBound("to", c.Query("to"), loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !to.After(from) || to.Sub(from) > maxCalendarRange {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from and within 366 days"})
+			return
+		}
+
+		events, err := listEventsBetween(db, userID, from.UTC(), to.UTC())
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusCreated, comment)
+		// 期間の端の面接は、期間外の面接との重なりも確認する
+		conflictFrom, conflictTo := from.Add(-defaultInterviewDuration), to
+		for _, e := range events {
+			if e.Type == EventTypeInterview && e.effectiveEnd().After(conflictTo) {
+				conflictTo = e.effectiveEnd()
+			}
+		}
+		nearby, err := listEventsBetween(db, userID, conflictFrom.UTC(), conflictTo.UTC())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		conflicts := interviewConflicts(nearby)
+
+		// 実施期間は tz の日付で判定する（to は含まないので前日まで）
+		internships, err := listInternshipsBetween(db, userID, from.In(loc).Format(dateLayout), to.Add(-time.Nanosecond).In(loc).Format(dateLayout))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		var listIDs, internshipIDs []uint
+		for _, e := range events {
+			if e.CompanyListID != nil {
+				listIDs = append(listIDs, *e.CompanyListID)
+			}
+			if e.InternshipID != nil {
+				internshipIDs = append(internshipIDs, *e.InternshipID)
+			}
+		}
+		listNames, err := companyNamesByIDs(db, userID, listIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		internshipNames, err := internshipCompaniesByIDs(db, userID, internshipIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		items := make([]calendarItem, 0, len(events)+len(internships))
+		for _, e := range events {
+			item := calendarItem{
+				Kind:          "event",
+				ID:            e.ID,
+				Type:          e.Type,
+				Title:         e.Title,
+				StartsAt:      e.StartsAt.In(loc),
+				TimeZone:      e.TimeZone,
+				Location:      e.Location,
+				OnlineURL:     e.OnlineURL,
+				CompanyListID: e.CompanyListID,
+				InternshipID:  e.InternshipID,
+				Conflicts:     conflicts[e.ID],
+			}
+			if e.EndsAt != nil {
+				t := e.EndsAt.In(loc)
+				item.EndsAt = &t
+			}
+			if e.CompanyListID != nil {
+				item.Company = listNames[*e.CompanyListID]
+			} else if e.InternshipID != nil {
+				item.Company = internshipNames[*e.InternshipID]
+			}
+			items = append(items, item)
+		}
+		for _, in := range internships {
+			if item, ok := internshipCalendarItem(in, loc); ok {
+				items = append(items, item)
+			}
+		}
+		sortCalendarItems(items)
+		c.JSON(http.StatusOK, items)
 	}
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// テスト用のパスワード
+const testPassword = "Zx9-long-test-pass"
+
+// initTestAuth はテスト用の鍵と試行制限で認証を初期化します
+func initTestAuth(t *testing.T) {
+	t.Helper()
+	err := InitAuth(&Config{
+		JWTSecret:                "test_secret_key_which_is_long_enough",
+		RefreshTokenTTL:          time.Hour,
+		LoginMaxFailuresPerEmail: 3,
+		LoginMaxFailuresPerIP:    100,
+		LoginLockDuration:        15 * time.Minute,
+		LoginBackoffBase:         time.Second,
+		LoginBackoffMax:          time.Minute,
+		PasswordMinLength:        8,
+	})
+	if err != nil {
+		t.Fatalf("InitAuth: %v", err)
+	}
+}
+
+// newTestUserWithPassword は testPassword でログインできるテスト用のユーザーを作成します
+func newTestUserWithPassword(t *testing.T, db *gorm.DB) *User {
+	t.Helper()
+	u := newTestUser(t, db)
+	hash, err := HashPassword(testPassword)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if err := db.Model(u).Update("password", hash).Error; err != nil {
+		t.Fatalf("update password: %v", err)
+	}
+	return u
+}
+
+// performJSON は JSON のリクエストを送り、レスポンスを返します（header は名前・値の順）
+func performJSON(h http.Handler, method, path string, body interface{}, header ...string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// decodeJSON はレスポンスの JSON オブジェクトを読み取ります
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	initTestAuth(t)
+	db := newTestDB(t)
+	u := newTestUserWithPassword(t, db)
+	r := gin.New()
+	r.POST("/login", loginHandler(db))
+	r.POST("/token/refresh", refreshTokenHandler(db))
+
+	w := performJSON(r, "POST", "/login", gin.H{"email": u.Email, "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	tokens := []string{decodeJSON(t, w)["refresh_token"].(string)}
+
+	expired, err := GenerateOpaqueToken(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createRefreshToken(db, &RefreshToken{
+		UserID: u.ID, TokenHash: HashToken(expired), FamilyID: "expired", ExpiresAt: time.Now().Add(-time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 順に実行する（token は発行済みのトークンの番号、-1 は tokens 以外）
+	steps := []struct {
+		name   string
+		token  int
+		raw    string
+		status int
+		error  string
+	}{
+		{name: "rotate the login token", token: 0, status: http.StatusOK},
+		{name: "rotate the rotated token", token: 1, status: http.StatusOK},
+		{name: "reuse a rotated token", token: 0, status: http.StatusUnauthorized, error: "refresh token reused"},
+		{name: "latest token of a revoked family", token: 2, status: http.StatusUnauthorized, error: "invalid refresh token"},
+		{name: "unknown token", token: -1, raw: "unknown", status: http.StatusUnauthorized, error: "invalid refresh token"},
+		{name: "expired token", token: -1, raw: expired, status: http.StatusUnauthorized, error: "invalid refresh token"},
+	}
+	for _, step := range steps {
+		token := step.raw
+		if step.token >= 0 {
+			token = tokens[step.token]
+		}
+		w := performJSON(r, "POST", "/token/refresh", gin.H{"refresh_token": token})
+		if w.Code != step.status {
+			t.Fatalf("%s: status = %d %s, want %d", step.name, w.Code, w.Body, step.status)
+		}
+		body := decodeJSON(t, w)
+		if step.status == http.StatusOK {
+			next, _ := body["refresh_token"].(string)
+			if next == "" || next == token {
+				t.Fatalf("%s: refresh_token = %q, want a new token", step.name, next)
+			}
+			if _, err := ParseJWT(body["token"].(string)); err != nil {
+				t.Fatalf("%s: ParseJWT: %v", step.name, err)
+			}
+			tokens = append(tokens, next)
+		} else if body["error"] != step.error {
+			t.Errorf("%s: error = %v, want %q", step.name, body["error"], step.error)
+		}
+	}
+
+	// 再利用を検知したらログインセッションも失効する
+	var sessions []Session
+	if err := db.Where("user_id = ?", u.ID).Find(&sessions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].RevokedAt == nil {
+		t.Errorf("sessions = %+v, want one revoked session", sessions)
+	}
+}
+
+func TestRecordLoginFailure(t *testing.T) {
+	db := newTestDB(t)
+	policy := LoginThrottlePolicy{LockDuration: 15 * time.Minute, BackoffBase: time.Second, BackoffMax: time.Minute}
+	start := time.Now()
+
+	// 順に記録する（at は start からの経過時間）
+	steps := []struct {
+		at       time.Duration
+		failures int
+		backoff  time.Duration
+		locked   bool
+	}{
+		{0, 1, time.Second, false},
+		{time.Minute, 2, 2 * time.Second, false},
+		{2 * time.Minute, 3, 4 * time.Second, true},
+		// ロック時間（カウント期間）を過ぎた失敗は 1 回目から数え直す
+		{20 * time.Minute, 1, time.Second, false},
+	}
+	for _, step := range steps {
+		now := start.Add(step.at)
+		a, err := recordLoginFailure(db, loginKindEmail, "user@example.com", 3, policy, now)
+		if err != nil {
+			t.Fatalf("recordLoginFailure: %v", err)
+		}
+		if a.Failures != step.failures {
+			t.Errorf("after %v: failures = %d, want %d", step.at, a.Failures, step.failures)
+		}
+		if a.NextAllowedAt == nil || !a.NextAllowedAt.Equal(now.Add(step.backoff)) {
+			t.Errorf("after %v: next_allowed_at = %v, want %v", step.at, a.NextAllowedAt, now.Add(step.backoff))
+		}
+		if locked := a.LockedUntil != nil && a.LockedUntil.After(now); locked != step.locked {
+			t.Errorf("after %v: locked = %v, want %v", step.at, locked, step.locked)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	initTestAuth(t)
+	// 待ち時間なしでロックだけを確かめる
+	loginThrottle.BackoffBase, loginThrottle.BackoffMax = 0, 0
+	db := newTestDB(t)
+	plain := newTestUserWithPassword(t, db)
+	withTOTP := newTestUserWithPassword(t, db)
+	if err := db.Model(withTOTP).Updates(map[string]interface{}{"totp_secret": "JBSWY3DPEHPK3PXP", "totp_enabled": true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/login", loginHandler(db))
+
+	const wrong = "wrong-password"
+	tests := []struct {
+		name      string
+		user      *User
+		passwords []string
+		status    []int
+	}{
+		{
+			name:      "locked after three failures",
+			user:      plain,
+			passwords: []string{wrong, wrong, wrong, testPassword},
+			status:    []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusLocked},
+		},
+		{
+			// パスワードが正しくても二要素認証が終わるまでは失敗回数を消さない
+			name:      "password step of a TOTP login does not reset failures",
+			user:      withTOTP,
+			passwords: []string{wrong, wrong, testPassword, wrong, testPassword},
+			status:    []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusOK, http.StatusUnauthorized, http.StatusLocked},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, pw := range tt.passwords {
+				w := performJSON(r, "POST", "/login", gin.H{"email": tt.user.Email, "password": pw})
+				if w.Code != tt.status[i] {
+					t.Fatalf("attempt %d: status = %d %s, want %d", i+1, w.Code, w.Body, tt.status[i])
+				}
+			}
+		})
+	}
+
+	t.Run("successful login resets failures", func(t *testing.T) {
+		u := newTestUserWithPassword(t, db)
+		for i, pw := range []string{wrong, wrong, testPassword, wrong, wrong, testPassword} {
+			if w := performJSON(r, "POST", "/login", gin.H{"email": u.Email, "password": pw}); (w.Code == http.StatusOK) != (pw == testPassword) {
+				t.Fatalf("attempt %d: status = %d %s", i+1, w.Code, w.Body)
+			}
+		}
+	})
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	initTestAuth(t)
+	db := newTestDB(t)
+	u := newTestUser(t, db)
+
+	now := time.Now()
+	revokedAt := now.Add(-time.Minute)
+	pats := map[string]string{}
+	for name, pat := range map[string]PersonalAccessToken{
+		"read":    {Scopes: ScopeRead, ExpiresAt: now.Add(time.Hour)},
+		"write":   {Scopes: ScopeWrite, ExpiresAt: now.Add(time.Hour)},
+		"both":    {Scopes: ScopeRead + "," + ScopeWrite, ExpiresAt: now.Add(time.Hour)},
+		"revoked": {Scopes: ScopeRead + "," + ScopeWrite, ExpiresAt: now.Add(time.Hour), RevokedAt: &revokedAt},
+		"expired": {Scopes: ScopeRead + "," + ScopeWrite, ExpiresAt: now.Add(-time.Minute)},
+	} {
+		raw, err := GenerateOpaqueToken(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = personalAccessTokenPrefix + raw
+		pat.UserID, pat.Name, pat.TokenHash, pat.Prefix = u.ID, name, HashToken(raw), raw[:8]
+		if err := createPersonalAccessToken(db, &pat); err != nil {
+			t.Fatalf("createPersonalAccessToken: %v", err)
+		}
+		pats[name] = raw
+	}
+	jwt, err := GenerateJWT(u.ID, u.Role, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pats["jwt"] = jwt
+
+	// main.go と同じ構成のルーター
+	r := gin.New()
+	authenticated := r.Group("/")
+	authenticated.Use(authMiddleware(db))
+	api := authenticated.Group("/")
+	api.Use(requireScope())
+	auth := authenticated.Group("/")
+	auth.Use(requireJWT())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	api.GET("/companies", ok)
+	api.POST("/companies", ok)
+	api.PUT("/companies/1", ok)
+	api.DELETE("/companies/1", ok)
+	auth.GET("/tokens", ok)
+
+	tests := []struct {
+		token        string
+		method, path string
+		status       int
+	}{
+		{"read", "GET", "/companies", http.StatusNoContent},
+		{"read", "POST", "/companies", http.StatusForbidden},
+		{"read", "PUT", "/companies/1", http.StatusForbidden},
+		{"read", "DELETE", "/companies/1", http.StatusForbidden},
+		{"write", "GET", "/companies", http.StatusForbidden},
+		{"write", "POST", "/companies", http.StatusNoContent},
+		{"write", "PUT", "/companies/1", http.StatusNoContent},
+		{"write", "DELETE", "/companies/1", http.StatusNoContent},
+		{"both", "GET", "/companies", http.StatusNoContent},
+		{"both", "POST", "/companies", http.StatusNoContent},
+		{"revoked", "GET", "/companies", http.StatusUnauthorized},
+		{"expired", "GET", "/companies", http.StatusUnauthorized},
+		// アカウント管理のルートは個人アクセストークンでは使えない
+		{"both", "GET", "/tokens", http.StatusForbidden},
+		{"jwt", "GET", "/tokens", http.StatusNoContent},
+		{"jwt", "POST", "/companies", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		w := performJSON(r, tt.method, tt.path, nil, "Authorization", "Bearer "+pats[tt.token])
+		if w.Code != tt.status {
+			t.Errorf("%s %s with %s token = %d %s, want %d", tt.method, tt.path, tt.token, w.Code, w.Body, tt.status)
+		}
+	}
+}
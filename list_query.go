@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 一覧の 1 ページの最大件数
+const maxListLimit = 200
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// 並び替えに使える項目（クエリパラメータ名 → カラム）
+type sortField struct {
+	Column string
+	Time   bool // カーソルの値を time.Time に戻す
+	UTC    bool // UTC で保存しているカラム（それ以外はサーバーのタイムゾーン）
+}
+
+// 並び替えのキー
+type sortKey struct {
+	Name string
+	sortField
+	Desc bool
+}
+
+// 一覧の絞り込み条件
+type listFilter struct {
+	Query string
+	Args  []interface{}
+}
+
+// 一覧取得の絞り込み・並び替え・ページングの条件
+// Limit が 0 の場合は全件を返す
+type ListQuery struct {
+	Filters []listFilter
+	Sort    []sortKey // 末尾は常に id（同じ値の行の順序を固定するため）
+	Limit   int
+	After   []interface{} // カーソルが指す行の並び替えキーの値
+}
+
+// カーソルの中身（並び替えの指定が変わったカーソルは拒否する）
+type listCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+// Where は絞り込み条件を追加します
+func (q *ListQuery) Where(query string, args ...interface{}) {
+	q.Filters = append(q.Filters, listFilter{Query: query, Args: args})
+}
+
+// filtered は絞り込み条件だけを適用します（件数の取得用）
+func (q ListQuery) filtered(tx *gorm.DB) *gorm.DB {
+	for _, f := range q.Filters {
+		tx = tx.Where(f.Query, f.Args...)
+	}
+	return tx
+}
+
+// paged は絞り込み・カーソル・並び替え・件数制限を適用します
+// 続きがあるか判定するため Limit より 1 件多く取得します
+func (q ListQuery) paged(tx *gorm.DB) *gorm.DB {
+	tx = q.filtered(tx)
+	if len(q.After) == len(q.Sort) {
+		// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+		var ors []string
+		var args []interface{}
+		for i, k := range q.Sort {
+			var ands []string
+			for j := 0; j < i; j++ {
+				ands = append(ands, q.Sort[j].Column+" = ?")
+				args = append(args, q.After[j])
+			}
+			op := " > ?"
+			if k.Desc {
+				op = " < ?"
+			}
+			ands = append(ands, k.Column+op)
+			args = append(args, q.After[i])
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+		tx = tx.Where("("+strings.Join(ors, " OR ")+")", args...)
+	}
+	for _, k := range q.Sort {
+		if k.Desc {
+			tx = tx.Order(k.Column + " DESC")
+		} else {
+			tx = tx.Order(k.Column + " ASC")
+		}
+	}
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit + 1)
+	}
+	return tx
+}
+
+// sortSpec は並び替えの指定を文字列にします（カーソルの照合用）
+func (q ListQuery) sortSpec() string {
+	names := make([]string, len(q.Sort))
+	for i, k := range q.Sort {
+		names[i] = k.Name
+		if k.Desc {
+			names[i] = "-" + k.Name
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Cursor は values（最後の行の並び替えキーの値）の次から始まるカーソルを返します
+func (q ListQuery) Cursor(values []interface{}) string {
+	b, _ := json.Marshal(listCursor{Sort: q.sortSpec(), Values: values})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseListQuery は sort・limit・cursor クエリパラメータを読み取ります
+// sort はカンマ区切りで、先頭に - を付けると降順（例: sort=-updated_at,company）
+func parseListQuery(c *gin.Context, fields map[string]sortField) (ListQuery, error) {
+	var q ListQuery
+	seen := map[string]bool{}
+	if s := c.Query("sort"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			name = strings.TrimSpace(name)
+			desc := strings.HasPrefix(name, "-")
+			name = strings.TrimPrefix(name, "-")
+			f, ok := fields[name]
+			if !ok {
+				return q, fmt.Errorf("cannot sort by %q", name)
+			}
+			if seen[name] {
+				return q, fmt.Errorf("duplicate sort key %q", name)
+			}
+			seen[name] = true
+			q.Sort = append(q.Sort, sortKey{Name: name, sortField: f, Desc: desc})
+		}
+	}
+	if !seen["id"] {
+		q.Sort = append(q.Sort, sortKey{Name: "id", sortField: sortField{Column: "id"}})
+	}
+
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxListLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		q.Limit = n
+	}
+
+	if s := c.Query("cursor"); s != "" {
+		if q.Limit == 0 {
+			return q, errors.New("cursor requires limit")
+		}
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return q, errInvalidCursor
+		}
+		var cur listCursor
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&cur); err != nil || cur.Sort != q.sortSpec() || len(cur.Values) != len(q.Sort) {
+			return q, errInvalidCursor
+		}
+		for i, k := range q.Sort {
+			v := cur.Values[i]
+			if n, ok := v.(json.Number); ok {
+				if v, err = n.Int64(); err != nil {
+					return q, errInvalidCursor
+				}
+			}
+			if k.Time {
+				s, ok := v.(string)
+				if !ok {
+					return q, errInvalidCursor
+				}
+				t, err := time.Parse(time.RFC3339Nano, s)
+				if err != nil {
+					return q, errInvalidCursor
+				}
+				if k.UTC {
+					v = t.UTC()
+				} else {
+					v = t.Local()
+				}
+			}
+			q.After = append(q.After, v)
+		}
+	}
+	return q, nil
+}
+
+// parseTimeRangeFilter は <name>_from / <name>_to（RFC 3339）を column の範囲条件として追加します
+// column はサーバーのタイムゾーンで保存した日時（created_at など）
+func parseTimeRangeFilter(c *gin.Context, q *ListQuery, name, column string) error {
+	return parseTimeRangeFilterIn(c, q, name, column, time.Local)
+}
+
+// parseTimeRangeFilterIn は column を保存しているタイムゾーン loc に合わせて範囲条件を追加します
+// SQLite では日時を文字列として比べるため、保存時と同じタイムゾーンにそろえる必要があります
+func parseTimeRangeFilterIn(c *gin.Context, q *ListQuery, name, column string, loc *time.Location) error {
+	for _, p := range []struct {
+		suffix string
+		op     string
+	}{{"_from", " >= ?"}, {"_to", " <= ?"}} {
+		if v := c.Query(name + p.suffix); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return fmt.Errorf("%s%s must be an RFC 3339 timestamp", name, p.suffix)
+			}
+			q.Where(column+p.op, t.In(loc))
+		}
+	}
+	return nil
+}
+
+// parseBoolFilter は true / false のクエリパラメータを column の条件として追加します
+func parseBoolFilter(c *gin.Context, q *ListQuery, name, column string) error {
+	if v := c.Query(name); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", name)
+		}
+		q.Where(column+" = ?", b)
+	}
+	return nil
+}
+
+// parseContainsFilter は部分一致（大文字小文字を区別しない）の条件を追加します
+func parseContainsFilter(c *gin.Context, q *ListQuery, name, column string) {
+	if v := strings.TrimSpace(c.Query(name)); v != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(v))
+		q.Where("LOWER("+column+") LIKE ? ESCAPE '\\'", "%"+escaped+"%")
+	}
+}
+
+// parseSearchFilter は q（空白区切りの語）の条件を追加します
+// すべての語が columns のいずれかに含まれる（大文字小文字を区別しない）レコードに絞り込みます
+func parseSearchFilter(c *gin.Context, q *ListQuery, columns ...string) {
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	for _, term := range strings.Fields(strings.ToLower(c.Query("q"))) {
+		pattern := "%" + escape.Replace(term) + "%"
+		conds := make([]string, len(columns))
+		args := make([]interface{}, len(columns))
+		for i, col := range columns {
+			conds[i] = "LOWER(" + col + ") LIKE ? ESCAPE '\\'"
+			args[i] = pattern
+		}
+		q.Where("("+strings.Join(conds, " OR ")+")", args...)
+	}
+}
+
+// parseStageFilter は stage（カンマ区切りで複数可）の条件を追加します
+func parseStageFilter(c *gin.Context, db *gorm.DB, q *ListQuery, userID uint) error {
+	s := c.Query("stage")
+	if s == "" {
+		return nil
+	}
+	var stages []string
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		if _, err := lookupStage(db, userID, key); err != nil {
+			return fmt.Errorf("unknown stage %q", key)
+		}
+		stages = append(stages, key)
+	}
+	q.Where("stage IN ?", stages)
+	return nil
+}
+
+// parseTagFilter は tags（タグ ID のカンマ区切り）と tag_match の条件を追加します
+// tag_match=any（既定）はいずれかのタグ、all はすべてのタグが付いたレコードに絞り込みます
+func parseTagFilter(c *gin.Context, db *gorm.DB, q *ListQuery, userID uint, joinTable, joinColumn string) error {
+	s := c.Query("tags")
+	if s == "" {
+		return nil
+	}
+	var ids []uint
+	for _, v := range strings.Split(s, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+		if err != nil || id == 0 {
+			return fmt.Errorf("invalid tag id %q", v)
+		}
+		ids = append(ids, uint(id))
+	}
+	tags, err := getTagsByIDs(db, userID, ids)
+	if err != nil {
+		return err
+	}
+	sub := "SELECT " + joinColumn + " FROM " + joinTable + " WHERE tag_id IN ?"
+	switch c.DefaultQuery("tag_match", "any") {
+	case "any":
+		q.Where("id IN ("+sub+")", ids)
+	case "all":
+		q.Where("id IN ("+sub+" GROUP BY "+joinColumn+" HAVING COUNT(DISTINCT tag_id) = ?)", ids, len(tags))
+	default:
+		return errors.New("tag_match must be any or all")
+	}
+	return nil
+}
+
+// setListHeaders は件数と次のページのカーソルをレスポンスヘッダーに設定します
+func setListHeaders(c *gin.Context, total int64, next string) {
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
+}
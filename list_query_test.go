@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestContext はクエリ文字列を持つ GET リクエストの gin.Context を作ります
+func newTestContext(query url.Values) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/?"+query.Encode(), nil)
+	return c
+}
+
+func TestParseListQuery(t *testing.T) {
+	cursor := ListQuery{Sort: []sortKey{
+		{Name: "member", sortField: companyListSortFields["member"], Desc: true},
+		{Name: "id", sortField: companyListSortFields["id"]},
+	}}.Cursor([]interface{}{30, 7})
+
+	tests := []struct {
+		name    string
+		query   url.Values
+		spec    string
+		limit   int
+		after   []interface{}
+		wantErr bool
+	}{
+		{name: "defaults to id", query: url.Values{}, spec: "id"},
+		{name: "descending keys", query: url.Values{"sort": {"-member, company"}, "limit": {"10"}}, spec: "-member,company,id", limit: 10},
+		{name: "explicit id", query: url.Values{"sort": {"-id"}}, spec: "-id"},
+		{name: "unknown key", query: url.Values{"sort": {"password"}}, wantErr: true},
+		{name: "duplicate key", query: url.Values{"sort": {"member,-member"}}, wantErr: true},
+		{name: "limit too small", query: url.Values{"limit": {"0"}}, wantErr: true},
+		{name: "limit too large", query: url.Values{"limit": {"201"}}, wantErr: true},
+		{name: "limit not a number", query: url.Values{"limit": {"ten"}}, wantErr: true},
+		{name: "cursor", query: url.Values{"sort": {"-member"}, "limit": {"2"}, "cursor": {cursor}}, spec: "-member,id", limit: 2, after: []interface{}{int64(30), int64(7)}},
+		{name: "cursor without limit", query: url.Values{"sort": {"-member"}, "cursor": {cursor}}, wantErr: true},
+		{name: "cursor for another sort", query: url.Values{"sort": {"member"}, "limit": {"2"}, "cursor": {cursor}}, wantErr: true},
+		{name: "broken cursor", query: url.Values{"limit": {"2"}, "cursor": {"%%%"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parseListQuery(newTestContext(tt.query), companyListSortFields)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseListQuery() = %+v, want error", q)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseListQuery() error = %v", err)
+			}
+			if got := q.sortSpec(); got != tt.spec {
+				t.Errorf("sort = %q, want %q", got, tt.spec)
+			}
+			if q.Limit != tt.limit {
+				t.Errorf("limit = %d, want %d", q.Limit, tt.limit)
+			}
+			if len(q.After) != len(tt.after) {
+				t.Fatalf("after = %v, want %v", q.After, tt.after)
+			}
+			for i := range tt.after {
+				if q.After[i] != tt.after[i] {
+					t.Errorf("after[%d] = %#v, want %#v", i, q.After[i], tt.after[i])
+				}
+			}
+		})
+	}
+
+	t.Run("cursor with a broken time", func(t *testing.T) {
+		bad := ListQuery{Sort: []sortKey{
+			{Name: "created_at", sortField: companyListSortFields["created_at"]},
+			{Name: "id", sortField: companyListSortFields["id"]},
+		}}.Cursor([]interface{}{"yesterday", 7})
+		query := url.Values{"sort": {"created_at"}, "limit": {"2"}, "cursor": {bad}}
+		if _, err := parseListQuery(newTestContext(query), companyListSortFields); !errors.Is(err, errInvalidCursor) {
+			t.Errorf("parseListQuery() error = %v, want %v", err, errInvalidCursor)
+		}
+	})
+}
+
+// ページをカーソルでたどった結果が、一度に取得した結果と同じ順序になることを確認する
+func TestQueryCompanyListsCursor(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db)
+	other := newTestUser(t, db)
+	created := time.Date(2026, 4, 1, 9, 0, 0, 0, time.Local)
+	members := []int{30, 10, 30, 20, 10, 30, 0}
+	for i, m := range members {
+		cl := CompanyList{Company: string(rune('A' + i)), Member: m, UserID: user.ID}
+		// created_at が同じ行を含めて、同じ値のキーの次のページが id で続くことを確かめる
+		cl.CreatedAt = created.Add(time.Duration(i/2) * time.Minute)
+		if err := db.Create(&cl).Error; err != nil {
+			t.Fatalf("create company list: %v", err)
+		}
+	}
+	if err := db.Create(&CompanyList{Company: "Other", Member: 30, UserID: other.ID}).Error; err != nil {
+		t.Fatalf("create company list: %v", err)
+	}
+
+	for _, sort := range []string{"-member,company", "member", "-created_at", "created_at,-id"} {
+		t.Run(sort, func(t *testing.T) {
+			all, total, _, err := queryCompanyLists(db, user.ID, mustParseListQuery(t, url.Values{"sort": {sort}}))
+			if err != nil {
+				t.Fatalf("queryCompanyLists: %v", err)
+			}
+			if total != int64(len(members)) || len(all) != len(members) {
+				t.Fatalf("total = %d, len = %d, want %d", total, len(all), len(members))
+			}
+
+			var paged []uint
+			query := url.Values{"sort": {sort}, "limit": {"3"}}
+			for page := 0; ; page++ {
+				if page > len(members) {
+					t.Fatal("cursor does not advance")
+				}
+				q := mustParseListQuery(t, query)
+				lists, _, more, err := queryCompanyLists(db, user.ID, q)
+				if err != nil {
+					t.Fatalf("queryCompanyLists: %v", err)
+				}
+				for _, cl := range lists {
+					paged = append(paged, cl.ID)
+				}
+				if !more {
+					break
+				}
+				query.Set("cursor", q.Cursor(lists[len(lists)-1].sortValues(q.Sort)))
+			}
+
+			var want []uint
+			for _, cl := range all {
+				want = append(want, cl.ID)
+			}
+			if !equalUints(paged, want) {
+				t.Errorf("paged ids = %v, want %v", paged, want)
+			}
+		})
+	}
+}
+
+func mustParseListQuery(t *testing.T, query url.Values) ListQuery {
+	t.Helper()
+	q, err := parseListQuery(newTestContext(query), companyListSortFields)
+	if err != nil {
+		t.Fatalf("parseListQuery(%v): %v", query, err)
+	}
+	return q
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mailer はメール送信の抽象化です
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer は設定（MAIL_DRIVER）に応じた Mailer を返します
+func NewMailer(config *Config) Mailer {
+	if config.MailDriver == "smtp" {
+		return &SMTPMailer{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.MailFrom,
+		}
+	}
+	return &LogMailer{Dir: config.MailLogDir}
+}
+
+// SMTPMailer は SMTP サーバー経由でメールを送信します
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := net.JoinHostPort(m.Host, m.Port)
+	return smtp.SendMail(addr, auth, m.From, []string{to}, buildMessage(m.From, to, subject, body))
+}
+
+// LogMailer はメールを実際には送信せず、ログまたはファイルに書き出します（ローカル開発・テスト用）
+// Dir が空の場合はログに出力し、指定されている場合は 1 通ずつ .eml ファイルとして保存します
+type LogMailer struct {
+	Dir string
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	if m.Dir == "" {
+		log.Printf("[mail] to=%s subject=%s\n%s", to, subject, body)
+		return nil
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(to))
+	return os.WriteFile(filepath.Join(m.Dir, name), buildMessage("", to, subject, body), 0o644)
+}
+
+// buildMessage は UTF-8 のプレーンテキストメールを組み立てます
+func buildMessage(from, to, subject, body string) []byte {
+	// ヘッダーインジェクション対策で改行を除去
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(from))
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(to))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", clean.Replace(subject)))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	return []byte(b.String())
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -14,7 +15,12 @@ func main() {
 	config := LoadConfig()
 	
 	// JWT 鍵を初期化
-	InitAuth(config)
+	if err := InitAuth(config); err != nil {
+		log.Fatalf("JWT 鍵の初期化エラー: %v", err)
+	}
+
+	// メール送信の初期化
+	mailer := NewMailer(config)
 
 	// ① DB 接続＆マイグレーション
 	db, err := openGormDB(config)
@@ -22,6 +28,26 @@ func main() {
 		log.Fatalf("DB 接続エラー: %v", err)
 	}
 
+	// サブコマンド（例: ./app promote-admin user@example.com）
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1:]); err != nil {
+			log.Fatalf("コマンドエラー: %v", err)
+		}
+		return
+	}
+
+	// 期限切れトークンの定期削除
+	startTokenCleanup(db, time.Hour)
+
+	// 退会の猶予期間が過ぎたアカウントの削除
+	startAccountPurge(db, time.Hour, config.AccountDeletionPostPolicy)
+
+	// 期限切れのエクスポートファイルの削除
+	startExportCleanup(db, time.Hour, config.ExportDir, config.ExportTTL)
+
+	// 予定のリマインダーの送信
+	startEventReminders(db, mailer, time.Minute)
+
 	// ② Gin ルーター初期化
 	if config.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -36,9 +62,9 @@ func main() {
 	
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     config.CORSAllowedOrigins,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "X-Total-Count", "X-Next-Cursor"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -57,35 +83,151 @@ func main() {
 		})
 	})
 	
+	// 他サービスがトークンを検証するための公開鍵（JWKS）
+	r.GET("/.well-known/jwks.json", jwksHandler())
+
 	// 認証不要ルート
-	r.POST("/register", registerHandler(db))
+	r.POST("/register", registerHandler(db, mailer, config.FrontendURL))
+	r.POST("/email/verify", verifyEmailHandler(db))
 	r.POST("/login", loginHandler(db))
+	r.POST("/login/mfa", mfaLoginHandler(db))
+	r.POST("/token/refresh", refreshTokenHandler(db))
+	r.POST("/password/forgot", forgotPasswordHandler(db, mailer, config.FrontendURL))
+	r.POST("/password/reset", resetPasswordHandler(db))
+	r.POST("/email/change/confirm", confirmEmailChangeHandler(db, mailer))
+	r.POST("/account/restore", restoreAccountHandler(db))
+
+	// 認証ミドルウェアの適用（JWT と個人アクセストークンの両方を受け付ける）
+	authenticated := r.Group("/")
+	authenticated.Use(authMiddleware(db))
+
+	// 個人アクセストークンでも使えるルート（read / write スコープで制御）
+	api := authenticated.Group("/")
+	api.Use(requireScope())
+
+	// JWT（ログイン）でのみ使えるルート
+	auth := authenticated.Group("/")
+	auth.Use(requireJWT())
+
+	// ログアウト
+	auth.POST("/logout", logoutHandler(db))
+	auth.POST("/logout/all", logoutAllHandler(db))
+
+	// 退会
+	auth.DELETE("/me", deleteAccountHandler(db, config.AccountDeletionGracePeriod, config.AccountDeletionPostPolicy))
 
-	// 認証ミドルウェアの適用
-	auth := r.Group("/")
-	auth.Use(authMiddleware())
+	// パスワード・メールアドレスの変更
+	auth.PUT("/me/password", changePasswordHandler(db))
+	auth.PUT("/me/email", changeEmailHandler(db, mailer, config.FrontendURL))
+
+	// 自分のアカウントのセキュリティイベント（監査ログ）
+	auth.GET("/me/security-events", mySecurityEventsHandler(db))
+
+	// 個人データのエクスポート
+	auth.GET("/me/export", exportDataHandler(db, config.ExportDir, config.ExportSyncMaxRecords, config.ExportTTL))
+	auth.GET("/me/export/jobs/:id", exportJobHandler(db))
+	auth.GET("/me/export/jobs/:id/download", downloadExportHandler(db))
+
+	// ログイン中の端末（セッション）
+	auth.GET("/sessions", listSessionsHandler(db))
+	auth.DELETE("/sessions/:id", revokeSessionHandler(db))
+
+	// 二要素認証（TOTP）の登録・解除
+	auth.POST("/mfa/totp/enroll", enrollTOTPHandler(db))
+	auth.POST("/mfa/totp/confirm", confirmTOTPHandler(db))
+	auth.POST("/mfa/totp/disable", disableTOTPHandler(db))
+
+	// メールアドレス確認メールの再送
+	auth.POST("/email/verify/resend", resendVerificationHandler(db, mailer, config.FrontendURL))
+
+	// 個人アクセストークンの管理
+	auth.POST("/tokens", createPersonalAccessTokenHandler(db))
+	auth.GET("/tokens", listPersonalAccessTokensHandler(db))
+	auth.DELETE("/tokens/:id", revokePersonalAccessTokenHandler(db))
 
 	// CompanyList 用 CRUD
-	auth.POST("/company_lists", createCompanyListHandler(db))
-	auth.GET("/company_lists", listCompanyListsHandler(db))
-	auth.PUT("/company_lists/:id", updateCompanyListHandler(db))
-	auth.DELETE("/company_lists/:id", deleteCompanyListHandler(db))
+	api.POST("/company_lists", createCompanyListHandler(db))
+	api.GET("/company_lists", listCompanyListsHandler(db))
+	api.POST("/company_lists/reorder", reorderCompanyListHandler(db))
+	api.PUT("/company_lists/:id", updateCompanyListHandler(db))
+	api.PATCH("/company_lists/:id", patchCompanyListHandler(db))
+	api.DELETE("/company_lists/:id", deleteCompanyListHandler(db))
+	api.PUT("/company_lists/:id/tags", setCompanyListTagsHandler(db))
+	api.GET("/company_lists/:id/timeline", companyTimelineHandler(db))
+	api.PUT("/company_lists/:id/timeline/:entryId", updateTimelineEntryHandler(db))
+
+	// ノート（企業研究・面接の記録・OB 訪問）
+	api.GET("/company_lists/:id/notes", listNotesHandler(db))
+	api.POST("/company_lists/:id/notes", createNoteHandler(db))
+	api.GET("/company_lists/:id/notes/:noteId", getNoteHandler(db))
+	api.PUT("/company_lists/:id/notes/:noteId", updateNoteHandler(db))
+	api.DELETE("/company_lists/:id/notes/:noteId", deleteNoteHandler(db))
+	api.GET("/notes", searchNotesHandler(db))
+
+	// 連絡先とやり取りの記録
+	api.GET("/company_lists/:id/contacts", listContactsHandler(db))
+	api.POST("/company_lists/:id/contacts", createContactHandler(db))
+	api.GET("/company_lists/:id/contacts/:contactId", getContactHandler(db))
+	api.PUT("/company_lists/:id/contacts/:contactId", updateContactHandler(db))
+	api.DELETE("/company_lists/:id/contacts/:contactId", deleteContactHandler(db))
+	api.GET("/company_lists/:id/contacts/:contactId/interactions", listContactInteractionsHandler(db))
+	api.POST("/company_lists/:id/contacts/:contactId/interactions", createContactInteractionHandler(db))
+	api.DELETE("/company_lists/:id/contacts/:contactId/interactions/:interactionId", deleteContactInteractionHandler(db))
+	api.GET("/contacts/due", dueContactsHandler(db, config.ContactFollowUpDays))
+
+	// 予定とカレンダー
+	api.GET("/events", listEventsHandler(db))
+	api.POST("/events", createEventHandler(db))
+	api.GET("/events/:id", getEventHandler(db))
+	api.PUT("/events/:id", updateEventHandler(db))
+	api.DELETE("/events/:id", deleteEventHandler(db))
+	api.GET("/calendar", calendarHandler(db))
+
+	// 選考ステージ（独自ステージの管理と集計）
+	api.GET("/selection_stages", listSelectionStagesHandler(db))
+	api.POST("/selection_stages", createSelectionStageHandler(db))
+	api.DELETE("/selection_stages/:id", deleteSelectionStageHandler(db))
+	api.GET("/selection_stages/summary", stageSummaryHandler(db))
 
 	// インターンシップ用 CRUD
-	auth.POST("/internships", createInternshipHandler(db))
-	auth.GET("/internships", listInternshipsHandler(db))
-	auth.PUT("/internships/:id", updateInternshipHandler(db))
-	auth.DELETE("/internships/:id", deleteInternshipHandler(db))
+	api.POST("/internships", createInternshipHandler(db))
+	api.GET("/internships", listInternshipsHandler(db))
+	api.PUT("/internships/:id", updateInternshipHandler(db))
+	api.PATCH("/internships/:id", patchInternshipHandler(db))
+	api.DELETE("/internships/:id", deleteInternshipHandler(db))
+	api.PUT("/internships/:id/tags", setInternshipTagsHandler(db))
 
-	// 掲示板用 CRUD
-	auth.POST("/posts", createPostHandler(db))
+	// タグ
+	api.GET("/tags", listTagsHandler(db))
+	api.POST("/tags", createTagHandler(db))
+	api.PUT("/tags/:id", updateTagHandler(db))
+	api.DELETE("/tags/:id", deleteTagHandler(db))
+	api.POST("/tags/:id/merge", mergeTagHandler(db))
+
+	// 掲示板用 CRUD（書き込みはメールアドレス確認済みのユーザーのみ）
+	verified := auth.Group("/")
+	verified.Use(requireVerifiedEmail(db))
+	verified.POST("/posts", createPostHandler(db))
 	auth.GET("/posts", getPostsHandler(db))
 	auth.GET("/posts/:id", getPostHandler(db))
 	auth.DELETE("/posts/:id", deletePostHandler(db))
-	auth.POST("/posts/:id/like", likePostHandler(db))
+	verified.POST("/posts/:id/like", likePostHandler(db))
 	auth.DELETE("/posts/:id/like", unlikePostHandler(db))
-	auth.POST("/posts/:id/comments", createCommentHandler(db))
+	verified.POST("/posts/:id/comments", createCommentHandler(db))
+
+
+	// 管理者用
+	admin := auth.Group("/admin")
+	admin.Use(requireRole(RoleAdmin))
+	admin.GET("/login-attempts", listLoginAttemptsHandler(db))
+	admin.DELETE("/login-attempts/:id", deleteLoginAttemptHandler(db))
+	admin.PUT("/users/:id/role", updateUserRoleHandler(db))
+	admin.GET("/audit-events", listAuditEventsHandler(db))
 
+	// モデレーター用
+	moderation := auth.Group("/moderation")
+	moderation.Use(requireRole(RoleModerator, RoleAdmin))
+	moderation.DELETE("/posts/:id", moderatorDeletePostHandler(db))
 
 	// サーバ起動
 	addr := fmt.Sprintf(":%s", config.Port)
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSON Merge Patch（RFC 7396）の Content-Type
+const mergePatchContentType = "application/merge-patch+json"
+
+// PATCH で更新できる項目の型
+type patchKind int
+
+const (
+	patchString patchKind = iota
+	patchInt
+	patchBool
+)
+
+// PATCH で更新できる項目（JSON のキー → カラム）
+type patchField struct {
+	Column   string
+	Kind     patchKind
+	NotEmpty bool // 空文字・null を許さない（作成時に必須の項目）
+}
+
+// readMergePatch はリクエストボディを JSON Merge Patch として読み取ります
+// Content-Type は application/merge-patch+json または application/json を受け付けます
+func readMergePatch(c *gin.Context) (map[string]json.RawMessage, error) {
+	if ct := c.GetHeader("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != mergePatchContentType && mt != "application/json") {
+			return nil, fmt.Errorf("Content-Type must be %s or application/json", mergePatchContentType)
+		}
+	}
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil || patch == nil {
+		return nil, errors.New("request body must be a JSON object")
+	}
+	return patch, nil
+}
+
+// takePatchString はパッチから key の文字列を取り出します（null は空文字）
+// ok はキーが含まれていたかどうか
+func takePatchString(patch map[string]json.RawMessage, key string) (value string, ok bool, err error) {
+	raw, ok := patch[key]
+	if !ok {
+		return "", false, nil
+	}
+	delete(patch, key)
+	if isJSONNull(raw) {
+		return "", true, nil
+	}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", true, fmt.Errorf("%s must be a string", key)
+	}
+	return value, true, nil
+}
+
+// patchUpdates はパッチの各項目を検証し、カラム → 値の更新内容に変換します
+// 明示された false・0・空文字もそのまま更新し、null は型のゼロ値にします
+// fields にないキーはエラーにします
+func patchUpdates(patch map[string]json.RawMessage, fields map[string]patchField) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(patch))
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	updates := map[string]interface{}{}
+	for _, key := range keys {
+		f, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+		raw := patch[key]
+		null := isJSONNull(raw)
+		if null && f.NotEmpty {
+			return nil, fmt.Errorf("%s must not be empty", key)
+		}
+		switch f.Kind {
+		case patchString:
+			var v string
+			if !null && json.Unmarshal(raw, &v) != nil {
+				return nil, fmt.Errorf("%s must be a string", key)
+			}
+			if f.NotEmpty && strings.TrimSpace(v) == "" {
+				return nil, fmt.Errorf("%s must not be empty", key)
+			}
+			updates[f.Column] = v
+		case patchInt:
+			var v int
+			if !null && json.Unmarshal(raw, &v) != nil {
+				return nil, fmt.Errorf("%s must be an integer", key)
+			}
+			updates[f.Column] = v
+		case patchBool:
+			var v bool
+			if !null && json.Unmarshal(raw, &v) != nil {
+				return nil, fmt.Errorf("%s must be a boolean", key)
+			}
+			updates[f.Column] = v
+		}
+	}
+	return updates, nil
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return strings.TrimSpace(string(raw)) == "null"
+}
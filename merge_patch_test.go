@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPatchUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{name: "empty patch", body: `{}`, want: map[string]interface{}{}},
+		{
+			name: "explicit zero values are kept",
+			body: `{"occupation": "", "member": 0, "intern": false}`,
+			want: map[string]interface{}{"occupation": "", "member": 0, "intern": false},
+		},
+		{
+			name: "null resets to the zero value",
+			body: `{"occupation": null, "member": null, "intern": null}`,
+			want: map[string]interface{}{"occupation": "", "member": 0, "intern": false},
+		},
+		{
+			name: "values",
+			body: `{"company": "Example", "member": 120, "intern": true}`,
+			want: map[string]interface{}{"company": "Example", "member": 120, "intern": true},
+		},
+		{name: "required field set to null", body: `{"company": null}`, wantErr: "company must not be empty"},
+		{name: "required field set to blank", body: `{"company": "  "}`, wantErr: "company must not be empty"},
+		{name: "unknown field", body: `{"user_id": 2}`, wantErr: `unknown field "user_id"`},
+		{name: "unknown fields are reported in key order", body: `{"z": 1, "a": 1}`, wantErr: `unknown field "a"`},
+		{name: "string type", body: `{"occupation": 1}`, wantErr: "occupation must be a string"},
+		{name: "integer type", body: `{"member": "10"}`, wantErr: "member must be an integer"},
+		{name: "integer fraction", body: `{"member": 1.5}`, wantErr: "member must be an integer"},
+		{name: "boolean type", body: `{"intern": "true"}`, wantErr: "intern must be a boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(tt.body), &patch); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			got, err := patchUpdates(patch, companyListPatchFields)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("patchUpdates() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("patchUpdates() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("patchUpdates() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTakePatchString(t *testing.T) {
+	tests := []struct {
+		body      string
+		value     string
+		ok        bool
+		wantErr   bool
+		remaining int
+	}{
+		{body: `{"stage": "offer", "member": 1}`, value: "offer", ok: true, remaining: 1},
+		{body: `{"stage": null}`, value: "", ok: true},
+		{body: `{"member": 1}`, ok: false, remaining: 1},
+		{body: `{"stage": 3}`, ok: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		var patch map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tt.body), &patch); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		value, ok, err := takePatchString(patch, "stage")
+		if value != tt.value || ok != tt.ok || (err != nil) != tt.wantErr {
+			t.Errorf("takePatchString(%s) = %q, %v, %v", tt.body, value, ok, err)
+		}
+		if len(patch) != tt.remaining {
+			t.Errorf("takePatchString(%s) left %d keys, want %d", tt.body, len(patch), tt.remaining)
+		}
+	}
+}
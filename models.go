@@ -1,11 +1,311 @@
 package main
 
-import "gorm.io/gorm"
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ユーザーの権限
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// 有効な権限かどうか
+func isValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
 
 type User struct {
 	gorm.Model
-	Email    string `gorm:"uniqueIndex;not null"`
-	Password string `gorm:"not null"` // bcrypt でハッシュ化したものを保存
+	Email      string     `gorm:"uniqueIndex;not null"`
+	Password   string     `gorm:"not null"` // bcrypt でハッシュ化したものを保存
+	VerifiedAt *time.Time `json:"verified_at"` // メールアドレス確認日時（未確認は nil）
+	Role       string     `gorm:"not null;default:user;index" json:"role"` // user / moderator / admin
+	// TOTP 二要素認証
+	TOTPSecret   string `json:"-"`            // 登録中または有効なシークレット
+	TOTPEnabled  bool   `json:"totp_enabled"` // 初回コードの確認で有効化
+	TOTPLastStep int64  `json:"-"`            // 最後に使われたタイムステップ（リプレイ防止）
+	// 退会の猶予期間中は削除予定日時が入り、この日時を過ぎるとデータが完全に削除される
+	DeletionScheduledAt *time.Time `gorm:"index" json:"deletion_scheduled_at"`
+}
+
+// TOTP のリカバリーコード（bcrypt でハッシュ化して保存、1 回限り）
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"not null"`
+	UsedAt   *time.Time
+}
+
+// リフレッシュトークンモデル
+// トークン本体は保存せず SHA-256 ハッシュのみ保存する
+// FamilyID はログイン（端末）ごとに発行され、ローテーション後も引き継がれる
+type RefreshToken struct {
+	gorm.Model
+	UserID     uint      `gorm:"index;not null"`
+	TokenHash  string    `gorm:"uniqueIndex;not null"`
+	FamilyID   string    `gorm:"index;not null"`
+	SessionID  uint      `gorm:"index"` // 発行元のログインセッション
+	DeviceName string
+	ExpiresAt  time.Time `gorm:"not null"`
+	RotatedAt  *time.Time // ローテーション済み（再利用検知に使う）
+	RevokedAt  *time.Time // 失効済み
+}
+
+// ログインセッション（端末ごと）
+// ログインで作成され、同じ端末のリフレッシュトークンとアクセストークン（sid）が紐づく
+type Session struct {
+	gorm.Model
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	DeviceName string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// JWT 失効リスト
+// JTI 指定の行は 1 トークンを、IssuedBefore 指定の行はその時刻以前に発行された
+// ユーザーの全トークンを失効させる。ExpiresAt を過ぎた行は定期的に削除される
+type RevokedToken struct {
+	ID           uint       `gorm:"primarykey"`
+	CreatedAt    time.Time
+	JTI          string     `gorm:"index"`
+	UserID       uint       `gorm:"index;not null"`
+	IssuedBefore *time.Time
+	ExpiresAt    time.Time  `gorm:"index;not null"`
+}
+
+// パスワードリセットトークン（1 回限り・期限付き、ハッシュのみ保存）
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// メールアドレス変更の確認トークン（1 回限り・期限付き、ハッシュのみ保存）
+// 確認時に users.email が OldEmail のままの場合だけ NewEmail に切り替える
+type EmailChangeRequest struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;not null"`
+	OldEmail  string    `gorm:"not null"`
+	NewEmail  string    `gorm:"not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// ログイン失敗の記録（メールアドレス単位と IP 単位）
+type LoginAttempt struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Kind          string     `gorm:"not null;uniqueIndex:idx_login_attempts_subject" json:"kind"` // email / ip
+	Subject       string     `gorm:"not null;uniqueIndex:idx_login_attempts_subject" json:"subject"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailedAt  *time.Time `json:"last_failed_at"`
+	NextAllowedAt *time.Time `json:"next_allowed_at"` // 指数バックオフで次に試行できる時刻
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// 監査ログのイベント種別
+const (
+	AuditLogin          = "login"
+	AuditPasswordChange = "password_change"
+	AuditPasswordReset  = "password_reset"
+	AuditEmailChange    = "email_change"
+	AuditMFAEnable      = "mfa_enable"
+	AuditMFADisable     = "mfa_disable"
+	AuditTokenCreate    = "token_create"
+	AuditTokenRevoke    = "token_revoke"
+	AuditRoleChange     = "role_change"
+	AuditDataExport     = "data_export"
+	AuditAccountDelete  = "account_delete"
+	AuditAccountRestore = "account_restore"
+)
+
+// 監査ログの結果
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// 監査ログは追記専用
+var errAuditEventImmutable = errors.New("audit events are append-only")
+
+// セキュリティ監査ログ（追記専用）
+// 退会後もセキュリティ上の記録として残す。更新・削除はフックで拒否する
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	UserID    uint      `gorm:"index" json:"user_id"`  // 対象ユーザー（未登録のメールアドレスでのログイン失敗は 0）
+	ActorID   uint      `json:"actor_id,omitempty"`    // 本人以外（管理者）が操作した場合
+	Type      string    `gorm:"not null;index" json:"type"`
+	Result    string    `gorm:"not null" json:"result"` // success / failure
+	Email     string    `json:"email,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+func (AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return errAuditEventImmutable
+}
+
+func (AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return errAuditEventImmutable
+}
+
+// 個人アクセストークン（スクリプト・外部連携用）
+// トークン本体は作成時に一度だけ返し、DB には SHA-256 ハッシュのみ保存する
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	Name       string     `gorm:"not null;size:100" json:"name"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	Prefix     string     `gorm:"not null" json:"prefix"` // 一覧で見分けるための先頭部分
+	Scopes     string     `gorm:"not null" json:"scopes"` // カンマ区切り（read, write）
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// 個人データのエクスポートジョブ
+// 生成した ZIP は ExportDir に保存し、ExpiresAt を過ぎると削除される
+type ExportJob struct {
+	gorm.Model
+	UserID      uint       `gorm:"index;not null" json:"user_id"`
+	Status      string     `gorm:"not null;index" json:"status"` // pending / running / completed / failed
+	Error       string     `json:"error,omitempty"`
+	FilePath    string     `json:"-"`
+	Size        int64      `json:"size"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// ユーザー独自の選考ステージ（例: リクルーター面談）
+// 組み込みステージ After の直後に並ぶ
+type SelectionStage struct {
+	gorm.Model
+	UserID uint   `gorm:"index;not null" json:"user_id"`
+	Name   string `gorm:"not null;size:50" json:"name"`
+	After  string `gorm:"not null" json:"after"`
+}
+
+// ユーザー定義のタグ（CompanyList・Internship に多対多で付ける）
+// タグ名はユーザーごとに重複できない。名前を変えると付けてあるすべてのレコードに反映される
+type Tag struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_tags_user_name" json:"user_id"`
+	Name      string    `gorm:"not null;size:50;uniqueIndex:idx_tags_user_name" json:"name"`
+	Color     string    `gorm:"not null;size:7" json:"color"` // #rrggbb
+}
+
+// ノートの種類
+const (
+	NoteTypeResearch  = "research"  // 企業研究
+	NoteTypeInterview = "interview" // 面接の記録
+	NoteTypeOBVisit   = "ob_visit"  // OB・OG 訪問
+)
+
+// CompanyList に付けるノート（本文は Markdown）
+// Stage は関連する選考ステージ（任意）
+type Note struct {
+	gorm.Model
+	UserID        uint   `gorm:"index;not null" json:"user_id"`
+	CompanyListID uint   `gorm:"index;not null" json:"company_list_id"`
+	Type          string `gorm:"index;not null;size:20" json:"type"`
+	Title         string `gorm:"size:200" json:"title"`
+	Body          string `gorm:"type:text;not null" json:"body"`
+	Stage         string `json:"stage"`
+}
+
+// CompanyList の連絡先（人事・リクルーターなど）
+// LastContactedAt は最後に連絡した日時（やり取りを記録すると更新される）
+type Contact struct {
+	gorm.Model
+	UserID          uint       `gorm:"index;not null" json:"user_id"`
+	CompanyListID   uint       `gorm:"index;not null" json:"company_list_id"`
+	Name            string     `gorm:"size:100;not null" json:"name"`
+	Role            string     `gorm:"size:100" json:"role"`
+	Email           string     `gorm:"size:255" json:"email"`
+	Phone           string     `gorm:"size:50" json:"phone"`
+	LastContactedAt *time.Time `gorm:"index" json:"last_contacted_at"`
+	Notes           string     `gorm:"type:text" json:"notes"`
+}
+
+// 連絡先とのやり取りの種類
+const (
+	InteractionTypeEmail = "email"
+	InteractionTypeCall  = "call"
+	InteractionTypeEvent = "event"
+)
+
+// 連絡先とのやり取りの記録
+type ContactInteraction struct {
+	gorm.Model
+	UserID     uint      `gorm:"index;not null" json:"user_id"`
+	ContactID  uint      `gorm:"index;not null" json:"contact_id"`
+	Type       string    `gorm:"size:20;not null" json:"type"`
+	OccurredAt time.Time `gorm:"index;not null" json:"occurred_at"`
+	Summary    string    `gorm:"type:text" json:"summary"`
+}
+
+// 予定の種類
+const (
+	EventTypeESDeadline = "es_deadline" // ES の締め切り
+	EventTypeWebTest    = "web_test"
+	EventTypeInterview  = "interview"
+	EventTypeBriefing   = "briefing" // 説明会
+	EventTypeOther      = "other"
+)
+
+// CompanyList または Internship に紐づく予定
+// StartsAt・EndsAt は UTC で保存し、レスポンスでは TimeZone の時刻で返す
+type Event struct {
+	gorm.Model
+	UserID        uint            `gorm:"index;not null" json:"user_id"`
+	CompanyListID *uint           `gorm:"index" json:"company_list_id"`
+	InternshipID  *uint           `gorm:"index" json:"internship_id"`
+	Type          string          `gorm:"size:20;index;not null" json:"type"`
+	Title         string          `gorm:"size:200;not null" json:"title"`
+	StartsAt      time.Time       `gorm:"index;not null" json:"starts_at"`
+	EndsAt        *time.Time      `json:"ends_at"` // 締め切りなど終わりのない予定は nil
+	TimeZone      string          `gorm:"size:64;not null" json:"time_zone"`
+	Location      string          `gorm:"size:255" json:"location"`
+	OnlineURL     string          `gorm:"size:2048" json:"online_url"`
+	Reminders     []EventReminder `json:"reminders"`
+}
+
+// 予定のリマインダー（開始の MinutesBefore 分前にメールで通知）
+type EventReminder struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	EventID       uint       `gorm:"index;not null" json:"event_id"`
+	MinutesBefore int        `gorm:"not null" json:"minutes_before"`
+	RemindAt      time.Time  `gorm:"index;not null" json:"remind_at"`
+	SentAt        *time.Time `json:"sent_at"`
+}
+
+// 選考ステージの変更履歴（CompanyList ごとのタイムライン）
+// ChangedAt は実際にステージが変わった日時で、後から記録する場合は過去の日時を指定できる
+type StageChange struct {
+	gorm.Model
+	UserID        uint      `gorm:"index;not null" json:"user_id"`
+	CompanyListID uint      `gorm:"index;not null" json:"company_list_id"`
+	FromStage     string    `json:"from_stage"`
+	ToStage       string    `json:"to_stage"`
+	Selection     string    `json:"selection"` // 変更時点の選考状況のラベル
+	ChangedAt     time.Time `gorm:"index;not null" json:"changed_at"`
+	Note          string    `json:"note"`
 }
 
 // 企業名
@@ -19,8 +319,11 @@ type CompanyList struct {
 	Occupation string
 	Member     int `gorm:"index;not null"`
 	Selection  string
+	Stage      string `gorm:"index"` // 選考ステージ（stages.go）、Selection は表示用のラベル
 	Intern     bool
-	UserID     uint `gorm:"index;not null"`
+	Rank       int64 `gorm:"index;not null;default:0"` // 志望順位（小さいほど上位、ranking.go）
+	UserID     uint  `gorm:"index;not null"`
+	Tags       []Tag `gorm:"many2many:company_list_tags"`
 }
 
 // 後々にインターンモデルも作成予定(モデル名Internship)
@@ -32,8 +335,12 @@ type Internship struct {
 	Dailyfinish int
 	Content     string
 	Selection   string
+	Stage       string `gorm:"index"` // 選考ステージ（stages.go）
 	Joined      bool
-	UserID      uint `gorm:"index;not null"`
+	StartsOn    string `gorm:"size:10;index"` // 実施期間（YYYY-MM-DD、未定なら空）
+	EndsOn      string `gorm:"size:10"`       // 空なら StartsOn の 1 日だけ
+	UserID      uint   `gorm:"index;not null"`
+	Tags        []Tag  `gorm:"many2many:internship_tags"`
 }
 
 // 掲示板投稿モデル
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// 同梱のよく使われる・漏洩済みパスワード一覧
+//
+//go:embed common_passwords.txt
+var bundledCommonPasswords string
+
+// パスワードの強度ポリシー
+type PasswordPolicy struct {
+	MinLength       int                 // 最小文字数
+	RejectEmail     bool                // メールアドレス（またはその @ より前）を含むパスワードを拒否
+	CommonPasswords map[string]struct{} // 拒否するパスワード（小文字）。nil ならチェックしない
+}
+
+// FieldError は入力項目ごとのエラーです
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// bcrypt が扱えるのは 72 バイトまで
+const passwordMaxBytes = 72
+
+// Validate はパスワードがポリシーを満たすか確認し、違反をすべて返します
+func (p PasswordPolicy) Validate(field, password, email string) []FieldError {
+	var errs []FieldError
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		errs = append(errs, FieldError{Field: field, Code: "too_short",
+			Message: fmt.Sprintf("password must be at least %d characters", p.MinLength)})
+	}
+	if len(password) > passwordMaxBytes {
+		errs = append(errs, FieldError{Field: field, Code: "too_long",
+			Message: fmt.Sprintf("password must be at most %d bytes", passwordMaxBytes)})
+	}
+	lower := strings.ToLower(password)
+	if p.RejectEmail && email != "" {
+		email = strings.ToLower(email)
+		local, _, _ := strings.Cut(email, "@")
+		if strings.Contains(lower, email) || (len(local) >= 3 && strings.Contains(lower, local)) {
+			errs = append(errs, FieldError{Field: field, Code: "contains_email",
+				Message: "password must not contain your email address"})
+		}
+	}
+	if p.CommonPasswords != nil {
+		if _, ok := p.CommonPasswords[lower]; ok {
+			errs = append(errs, FieldError{Field: field, Code: "common_password",
+				Message: "password is too common or has appeared in a data breach"})
+		}
+	}
+	return errs
+}
+
+// loadCommonPasswords は同梱の一覧と、指定があれば追加のファイル（1 行 1 件）を読み込みます
+func loadCommonPasswords(extraFile string) (map[string]struct{}, error) {
+	set := map[string]struct{}{}
+	add := func(line string) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			set[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	for _, line := range strings.Split(bundledCommonPasswords, "\n") {
+		add(line)
+	}
+	if extraFile == "" {
+		return set, nil
+	}
+	f, err := os.Open(extraFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		add(scanner.Text())
+	}
+	return set, scanner.Err()
+}
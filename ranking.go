@@ -0,0 +1,19 @@
+package main
+
+import "errors"
+
+// 志望順位（CompanyList.Rank）の間隔
+// 新しいレコードは末尾に rankGap を足した順位になり、並べ替えでは前後の順位の中間を使う。
+// 間が詰まって中間が取れなくなったときだけ、そのユーザーの順位を振り直す
+const rankGap int64 = 1 << 16
+
+// 並べ替えの基準（before_id / after_id）のレコードが見つからない
+var errUnknownRankAnchor = errors.New("reorder anchor not found")
+
+// rankBetween は lo と hi の間の順位を返します（間がなければ ok=false）
+func rankBetween(lo, hi int64) (rank int64, ok bool) {
+	if hi-lo < 2 {
+		return 0, false
+	}
+	return lo + (hi-lo)/2, true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestRankBetween(t *testing.T) {
+	tests := []struct {
+		lo, hi int64
+		want   int64
+		ok     bool
+	}{
+		{0, rankGap, rankGap / 2, true},
+		{-rankGap, 0, -rankGap / 2, true},
+		{10, 13, 11, true},
+		{10, 12, 11, true},
+		{10, 11, 0, false},
+		{10, 10, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := rankBetween(tt.lo, tt.hi)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("rankBetween(%d, %d) = %d, %v, want %d, %v", tt.lo, tt.hi, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMoveCompanyList(t *testing.T) {
+	tests := []struct {
+		name       string
+		ranks      []int64 // 作成するレコードの順位（id は 1 から順に振られる）
+		id, anchor uint
+		before     bool
+		order      []uint
+		rank       int64
+		rebalanced bool
+	}{
+		{name: "to the top", ranks: []int64{rankGap, 2 * rankGap, 3 * rankGap}, id: 3, anchor: 1, before: true, order: []uint{3, 1, 2}, rank: 0},
+		{name: "to the bottom", ranks: []int64{rankGap, 2 * rankGap, 3 * rankGap}, id: 1, anchor: 3, order: []uint{2, 3, 1}, rank: 4 * rankGap},
+		{name: "between two records", ranks: []int64{rankGap, 2 * rankGap, 3 * rankGap}, id: 3, anchor: 2, before: true, order: []uint{1, 3, 2}, rank: rankGap + rankGap/2},
+		{name: "after a record", ranks: []int64{rankGap, 2 * rankGap, 3 * rankGap}, id: 1, anchor: 2, order: []uint{2, 1, 3}, rank: 2*rankGap + rankGap/2},
+		{name: "no gap left", ranks: []int64{10, 11, 12}, id: 3, anchor: 2, before: true, order: []uint{1, 3, 2}, rank: rankGap + rankGap/2, rebalanced: true},
+		{name: "equal ranks", ranks: []int64{5, 5, 5}, id: 1, anchor: 2, order: []uint{2, 1, 3}, rank: 2*rankGap + rankGap/2, rebalanced: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			user := newTestUser(t, db)
+			for _, r := range tt.ranks {
+				if err := db.Create(&CompanyList{Company: "Company", Rank: r, UserID: user.ID}).Error; err != nil {
+					t.Fatalf("create company list: %v", err)
+				}
+			}
+			cl, rebalanced, err := moveCompanyList(db, user.ID, tt.id, tt.anchor, tt.before)
+			if err != nil {
+				t.Fatalf("moveCompanyList: %v", err)
+			}
+			if cl.Rank != tt.rank || rebalanced != tt.rebalanced {
+				t.Errorf("rank = %d, rebalanced = %v, want %d, %v", cl.Rank, rebalanced, tt.rank, tt.rebalanced)
+			}
+			if got := companyListOrder(t, db, user.ID); !equalUints(got, tt.order) {
+				t.Errorf("order = %v, want %v", got, tt.order)
+			}
+		})
+	}
+}
+
+func TestMoveCompanyListUnknownAnchor(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db)
+	other := newTestUser(t, db)
+	mine := CompanyList{Company: "Mine", Rank: rankGap, UserID: user.ID}
+	theirs := CompanyList{Company: "Theirs", Rank: rankGap, UserID: other.ID}
+	for _, cl := range []*CompanyList{&mine, &theirs} {
+		if err := db.Create(cl).Error; err != nil {
+			t.Fatalf("create company list: %v", err)
+		}
+	}
+	for _, anchor := range []uint{theirs.ID, 999} {
+		if _, _, err := moveCompanyList(db, user.ID, mine.ID, anchor, true); !errors.Is(err, errUnknownRankAnchor) {
+			t.Errorf("moveCompanyList(anchor %d) error = %v, want %v", anchor, err, errUnknownRankAnchor)
+		}
+	}
+	if _, _, err := moveCompanyList(db, user.ID, theirs.ID, mine.ID, true); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("moveCompanyList(another user's record) error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
+
+func TestRebalanceCompanyRanks(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db)
+	other := newTestUser(t, db)
+	for _, cl := range []CompanyList{
+		{Company: "A", Rank: 3, UserID: user.ID},
+		{Company: "B", Rank: 3, UserID: user.ID},
+		{Company: "C", Rank: -7, UserID: user.ID},
+		{Company: "D", Rank: 1, UserID: other.ID},
+	} {
+		if err := db.Create(&cl).Error; err != nil {
+			t.Fatalf("create company list: %v", err)
+		}
+	}
+	if err := rebalanceCompanyRanks(db, user.ID); err != nil {
+		t.Fatalf("rebalanceCompanyRanks: %v", err)
+	}
+
+	want := map[uint]int64{3: rankGap, 1: 2 * rankGap, 2: 3 * rankGap, 4: 1}
+	var lists []CompanyList
+	if err := db.Find(&lists).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	for _, cl := range lists {
+		if cl.Rank != want[cl.ID] {
+			t.Errorf("rank of %d = %d, want %d", cl.ID, cl.Rank, want[cl.ID])
+		}
+	}
+}
+
+// companyListOrder はユーザーの CompanyList の id を志望順に返します
+func companyListOrder(t *testing.T, db *gorm.DB, userID uint) []uint {
+	t.Helper()
+	var ids []uint
+	if err := db.Model(&CompanyList{}).Where("user_id = ?", userID).Order("rank ASC, id ASC").Pluck("id", &ids).Error; err != nil {
+		t.Fatalf("pluck: %v", err)
+	}
+	return ids
+}
@@ -1,6 +1,31 @@
 package main
 
-import "gorm.io/gorm"
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// 既にローテーション済みのリフレッシュトークンが再利用された
+	errRefreshTokenReused = errors.New("refresh token reused")
+	// パスワードリセットトークンが存在しない・期限切れ・使用済み
+	errInvalidResetToken = errors.New("invalid or expired reset token")
+	// メールアドレス変更トークンが存在しない・期限切れ・使用済み
+	errInvalidEmailChangeToken = errors.New("invalid or expired email change token")
+	// 変更先のメールアドレスが既に使われている
+	errEmailTaken = errors.New("email already in use")
+	// 独自ステージが CompanyList / インターンシップで使われている
+	errStageInUse = errors.New("stage is in use")
+	// 同じ名前のタグが既にある
+	errTagNameTaken = errors.New("tag name already in use")
+	// 存在しない（または他のユーザーの）タグが指定された
+	errUnknownTag = errors.New("unknown tag")
+)
 
 // 新規ユーザーの登録
 func createUser(db *gorm.DB, email, pwHash string) (*User, error) {
@@ -15,7 +40,7 @@ func createUser(db *gorm.DB, email, pwHash string) (*User, error) {
 func listCompanyLists(db *gorm.DB, userID uint) ([]CompanyList, error) {
 	var lists []CompanyList
 	// WHERE user_id = ? で自分のレコードだけを絞り込み、Find で全件取得
-	if err := db.
+	if err := preloadTags(db).
 		Where("user_id = ?", userID).
 		Find(&lists).
 		Error; err != nil {
@@ -33,6 +58,43 @@ func getUserByEmail(db *gorm.DB, email string) (*User, error) {
 	return &u, nil
 }
 
+// ID でユーザーを1件取得
+func getUserByID(db *gorm.DB, id uint) (*User, error) {
+	var u User
+	if err := db.First(&u, id).Error; err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ユーザーの権限を変更
+func updateUserRole(db *gorm.DB, userID uint, role string) error {
+	res := db.Model(&User{}).Where("id = ?", userID).Update("role", role)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// 指定した権限を持つユーザー数
+func countUsersByRole(db *gorm.DB, role string) (int64, error) {
+	var count int64
+	err := db.Model(&User{}).Where("role = ?", role).Count(&count).Error
+	return count, err
+}
+
+// メールアドレスを確認済みにする
+// 確認リンク発行後にメールアドレスが変わっていた場合は更新しない
+func markUserVerified(db *gorm.DB, userID uint, email string) (bool, error) {
+	res := db.Model(&User{}).
+		Where("id = ? AND email = ? AND verified_at IS NULL", userID, email).
+		Update("verified_at", time.Now())
+	return res.RowsAffected > 0, res.Error
+}
+
 // ログイン中のユーザーに紐ずくタスクを新規作成
 func createCompanyList(
 	db *gorm.DB,
@@ -41,6 +103,7 @@ func createCompanyList(
 	occupation string,
 	member int,
 	selection string,
+	stage string,
 	intern bool,
 ) (*CompanyList, error) {
 	cl := &CompanyList{
@@ -48,9 +111,17 @@ func createCompanyList(
 		Occupation: occupation, // 職種
 		Member:     member,     // 人数
 		Selection:  selection,  // 選考ステータス
+		Stage:      stage,      // 選考ステージ
 		Intern:     intern,     // インターン希望フラグ
 		UserID:     userID,     // ユーザーとの紐付け
 	}
+	// 志望順位は末尾に追加する
+	var maxRank int64
+	if err := db.Model(&CompanyList{}).Where("user_id = ?", userID).
+		Select("COALESCE(MAX(rank), 0)").Scan(&maxRank).Error; err != nil {
+		return nil, err
+	}
+	cl.Rank = maxRank + rankGap
 	if err := db.Create(cl).Error; err != nil {
 		return nil, err
 	}
@@ -68,24 +139,62 @@ func updateCompanyList(
 	occupation string,
 	member int,
 	selection string,
+	stage string,
 	intern bool) error {
-	return db.Model(&CompanyList{}).Where("id=? AND user_id=?", id, userID).
+	// Select で列を指定し、false・0・空文字も更新する
+	res := db.Model(&CompanyList{}).Where("id=? AND user_id=?", id, userID).
+		Select("company", "occupation", "member", "selection", "stage", "intern").
 		Updates(CompanyList{
 			Company:    company,
 			Occupation: occupation,
 			Member:     member,
 			Selection:  selection,
+			Stage:      stage,
 			Intern:     intern,
-		}).Error
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	// 該当するレコードがない（他のユーザーのものを含む）
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
-// ユーザーのタスクを削除
+// ユーザーのタスクを削除し、削除したレコードを返す
+// ステージ履歴・タグの付け外し・ノート・連絡先（やり取りを含む）・予定も合わせて削除する
 func deleteCompanyList(
 	db *gorm.DB,
 	id uint,
 	userID uint,
-) error {
-	return db.Where("id = ? AND user_id = ?", id, userID).Delete(&CompanyList{}).Error
+) (*CompanyList, error) {
+	var cl CompanyList
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&cl).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM company_list_tags WHERE company_list_id = ?", cl.ID).Error; err != nil {
+			return err
+		}
+		contacts := tx.Model(&Contact{}).Select("id").Where("company_list_id = ?", cl.ID)
+		if err := tx.Where("contact_id IN (?)", contacts).Delete(&ContactInteraction{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&StageChange{}, &Note{}, &Contact{}} {
+			if err := tx.Where("company_list_id = ?", cl.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := deleteLinkedEvents(tx, "company_list_id", cl.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&cl).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cl, nil
 }
 
 
@@ -100,7 +209,10 @@ func createInternship(
     dailyfinish int,
     content string,
     selection string,
+    stage string,
     joined bool,
+    startsOn string,
+    endsOn string,
 ) (*Internship, error) {
 	i := &Internship{
 		UserID: userID,
@@ -110,7 +222,10 @@ func createInternship(
 		Dailyfinish: dailyfinish,
 		Content: content,
 		Selection: selection,
+		Stage: stage,
 		Joined: joined,
+		StartsOn: startsOn,
+		EndsOn: endsOn,
 	}
 	if err := db.Create(i).Error; err != nil{
 		return nil, err
@@ -122,7 +237,7 @@ func createInternship(
 func listInternships(db *gorm.DB, userID uint) ([]Internship, error){
 	var internships []Internship
 	//引数のuserIDを使いそれに該当するものを探し、見つけたら新しいinternshipsに格納
-	if err := db.Where("user_id = ?", userID).Find(&internships).Error; err != nil{
+	if err := preloadTags(db).Where("user_id = ?", userID).Find(&internships).Error; err != nil{
 		return nil, err
 	}
 	return internships, nil
@@ -139,11 +254,16 @@ func updateInternship(
 	dailyfinish int,
 	content string,
 	selection string,
+	stage string,
 	joined bool,
+	startsOn string,
+	endsOn string,
 ) error {
 	//{}がないと初期化されない→中身が不定になる
-	return db.Model(&Internship{}).
+	// Select で列を指定し、false・0・空文字も更新する
+	res := db.Model(&Internship{}).
 	Where("id = ? AND user_id = ?", id, userID).
+	Select("title", "company", "dailystart", "dailyfinish", "content", "selection", "stage", "joined", "starts_on", "ends_on").
 	Updates(Internship{
 		Title:       title,
 		Company:     company,
@@ -151,13 +271,89 @@ func updateInternship(
 		Dailyfinish: dailyfinish,
 		Content:     content,
 		Selection:   selection,
+		Stage:       stage,
 		Joined:      joined,
-	}).Error
+		StartsOn:    startsOn,
+		EndsOn:      endsOn,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	// 該当するレコードがない（他のユーザーのものを含む）
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CompanyList の指定された項目だけを更新し、更新後のレコードを返す
+// map で渡すため false・0・空文字も更新される
+func patchCompanyList(db *gorm.DB, id uint, userID uint, updates map[string]interface{}) (*CompanyList, error) {
+	if len(updates) > 0 {
+		res := db.Model(&CompanyList{}).Where("id = ? AND user_id = ?", id, userID).Updates(updates)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+	return getCompanyList(db, id, userID)
+}
+
+// インターンシップの指定された項目だけを更新し、更新後のレコードを返す
+// map で渡すため false・0・空文字も更新される
+func patchInternship(db *gorm.DB, id uint, userID uint, updates map[string]interface{}) (*Internship, error) {
+	if len(updates) > 0 {
+		res := db.Model(&Internship{}).Where("id = ? AND user_id = ?", id, userID).Updates(updates)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+	return getInternship(db, id, userID)
+}
+
+// ユーザーの CompanyList を 1 件取得
+func getCompanyList(db *gorm.DB, id uint, userID uint) (*CompanyList, error) {
+	var cl CompanyList
+	if err := preloadTags(db).Where("id = ? AND user_id = ?", id, userID).First(&cl).Error; err != nil {
+		return nil, err
+	}
+	return &cl, nil
+}
+
+// ユーザーのインターンシップを 1 件取得
+func getInternship(db *gorm.DB, id uint, userID uint) (*Internship, error) {
+	var in Internship
+	if err := preloadTags(db).Where("id = ? AND user_id = ?", id, userID).First(&in).Error; err != nil {
+		return nil, err
+	}
+	return &in, nil
 }
 
-//削除処理
-func deleteInternship(db *gorm.DB, id uint, userID uint) error{
-	return db.Where("id = ? AND user_id = ?", id, userID).Delete(&Internship{}).Error
+//削除処理（削除したレコードを返す）
+//タグの付け外しと紐づく予定も合わせて削除する
+func deleteInternship(db *gorm.DB, id uint, userID uint) (*Internship, error){
+	var in Internship
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&in).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM internship_tags WHERE internship_id = ?", in.ID).Error; err != nil {
+			return err
+		}
+		if err := deleteLinkedEvents(tx, "internship_id", in.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&in).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &in, nil
 }
 
 // 掲示板関連のリポジトリ関数
@@ -181,9 +377,29 @@ func getPost(db *gorm.DB, postID uint) (Post, error) {
 	return post, err
 }
 
-// 投稿削除（投稿者のみ）
-func deletePost(db *gorm.DB, postID uint, userID uint) error {
-	return db.Where("id = ? AND user_id = ?", postID, userID).Delete(&Post{}).Error
+// 投稿削除（投稿者のみ）、削除した投稿を返す
+func deletePost(db *gorm.DB, postID uint, userID uint) (*Post, error) {
+	return deletePostWhere(db, "id = ? AND user_id = ?", postID, userID)
+}
+
+// 投稿削除（モデレーターによる削除、投稿者を問わない）、削除した投稿を返す
+func deletePostByModerator(db *gorm.DB, postID uint) (*Post, error) {
+	return deletePostWhere(db, "id = ?", postID)
+}
+
+// 条件に一致する投稿を 1 件削除（見つからなければ gorm.ErrRecordNotFound）
+func deletePostWhere(db *gorm.DB, query string, args ...interface{}) (*Post, error) {
+	var post Post
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(query, args...).First(&post).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&post).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
 }
 
 // いいね追加
@@ -209,9 +425,13 @@ func createLike(db *gorm.DB, like *Like) error {
 // いいね削除
 func deleteLike(db *gorm.DB, postID uint, userID uint) error {
 	return db.Transaction(func(tx *gorm.DB) error {
-		// いいねを削除
-		if err := tx.Where("post_id = ? AND user_id = ?", postID, userID).Delete(&Like{}).Error; err != nil {
-			return err
+		// いいねを削除（いいねしていなければカウントは変えない）
+		res := tx.Where("post_id = ? AND user_id = ?", postID, userID).Delete(&Like{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
 		}
 		// 投稿のいいねカウントを減らす
 		return tx.Model(&Post{}).Where("id = ?", postID).Update("like_count", gorm.Expr("like_count - ?", 1)).Error
@@ -244,3 +464,1757 @@ func getComments(db *gorm.DB, postID uint) ([]Comment, error) {
 	return comments, err
 }
 
+// リフレッシュトークン関連のリポジトリ関数
+
+// リフレッシュトークンを保存
+func createRefreshToken(db *gorm.DB, rt *RefreshToken) error {
+	return db.Create(rt).Error
+}
+
+// ハッシュからリフレッシュトークンを取得
+func getRefreshTokenByHash(db *gorm.DB, tokenHash string) (*RefreshToken, error) {
+	var rt RefreshToken
+	if err := db.Where("token_hash = ?", tokenHash).First(&rt).Error; err != nil {
+		return nil, err
+	}
+	return &rt, nil
+}
+
+// リフレッシュトークンをローテーション
+// 旧トークンを条件付き UPDATE で使用済みにし、同じ系列で新トークンを発行する
+// 同時リクエストで既に使用済みになっていた場合は errRefreshTokenReused を返す
+func rotateRefreshToken(db *gorm.DB, old *RefreshToken, newHash string, expiresAt time.Time) (*RefreshToken, error) {
+	next := &RefreshToken{
+		UserID:     old.UserID,
+		TokenHash:  newHash,
+		FamilyID:   old.FamilyID,
+		SessionID:  old.SessionID,
+		DeviceName: old.DeviceName,
+		ExpiresAt:  expiresAt,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", old.ID).
+			Update("rotated_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// 同じ系列のリフレッシュトークンをすべて失効
+func revokeRefreshTokenFamily(db *gorm.DB, familyID string) error {
+	return db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// セッション関連のリポジトリ関数
+
+// セッションを作成
+func createSession(db *gorm.DB, s *Session) error {
+	return db.Create(s).Error
+}
+
+// ID でセッションを取得
+func getSession(db *gorm.DB, id uint) (*Session, error) {
+	var s Session
+	if err := db.First(&s, id).Error; err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ユーザーの有効なセッション一覧（最近使われた順）
+func listActiveSessions(db *gorm.DB, userID uint) ([]Session, error) {
+	var sessions []Session
+	err := db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// 最終アクセス日時を更新（書き込みを減らすため 1 分以上経っている場合のみ）
+func touchSession(db *gorm.DB, id uint, now time.Time) error {
+	return db.Model(&Session{}).
+		Where("id = ? AND last_seen_at < ?", id, now.Add(-time.Minute)).
+		Update("last_seen_at", now).Error
+}
+
+// セッションを失効し、紐づくリフレッシュトークンも失効（失効したセッションを返す）
+func revokeSession(db *gorm.DB, id uint, userID uint) (*Session, error) {
+	var s Session
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&Session{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+			Update("revoked_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Model(&RefreshToken{}).
+			Where("session_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.First(&s, id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ユーザーのセッションとリフレッシュトークンを exceptSessionID 以外すべて失効
+// exceptSessionID が 0 の場合は全端末が対象
+func revokeUserSessions(db *gorm.DB, userID uint, exceptSessionID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, exceptSessionID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND (session_id IS NULL OR session_id <> ?) AND revoked_at IS NULL", userID, exceptSessionID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// JWT 失効リスト関連のリポジトリ関数
+
+// 1 つのアクセストークンを失効リストに追加
+func revokeToken(db *gorm.DB, jti string, userID uint, expiresAt time.Time) error {
+	return db.Create(&RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt}).Error
+}
+
+// ユーザーが現在までに発行した全アクセストークンを失効
+// アクセストークンの有効期限を過ぎれば行は不要になる
+func revokeAllUserTokens(db *gorm.DB, userID uint) error {
+	now := time.Now()
+	return db.Create(&RevokedToken{
+		UserID:       userID,
+		IssuedBefore: &now,
+		ExpiresAt:    now.Add(accessTokenTTL),
+	}).Error
+}
+
+// アクセストークンが失効済みかチェック
+func isTokenRevoked(db *gorm.DB, jti string, userID uint, issuedAt time.Time) (bool, error) {
+	var count int64
+	err := db.Model(&RevokedToken{}).
+		Where("jti = ? AND jti <> ''", jti).
+		Or("user_id = ? AND issued_before >= ?", userID, issuedAt).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// 期限切れの失効エントリと期限切れのリフレッシュトークンを削除
+func deleteExpiredTokens(db *gorm.DB, now time.Time) error {
+	if err := db.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return db.Unscoped().Where("expires_at < ?", now).Delete(&RefreshToken{}).Error
+}
+
+// パスワードリセット関連のリポジトリ関数
+
+// パスワードリセットトークンを保存
+func createPasswordResetToken(db *gorm.DB, t *PasswordResetToken) error {
+	return db.Create(t).Error
+}
+
+// 有効な（未使用・期限内の）パスワードリセットトークンを取得
+func getPasswordResetToken(db *gorm.DB, tokenHash string) (*PasswordResetToken, error) {
+	var t PasswordResetToken
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// パスワードリセットトークンを消費してパスワードを更新
+// 条件付き UPDATE で使用済みにするため、同じトークンは 1 回しか使えない
+// 同じユーザーの未使用トークンもまとめて無効化し、更新したユーザーの ID を返す
+func consumePasswordResetToken(db *gorm.DB, tokenHash string, pwHash string) (uint, error) {
+	var userID uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var t PasswordResetToken
+		if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+			First(&t).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvalidResetToken
+			}
+			return err
+		}
+		res := tx.Model(&PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", t.ID).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errInvalidResetToken
+		}
+		if err := tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", t.UserID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id = ?", t.UserID).Update("password", pwHash).Error; err != nil {
+			return err
+		}
+		userID = t.UserID
+		return nil
+	})
+	return userID, err
+}
+
+// パスワードを変更
+// 個人アクセストークンと未使用のパスワードリセットトークンも同じトランザクションで無効化する
+func updateUserPassword(db *gorm.DB, userID uint, pwHash string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).Update("password", pwHash).Error; err != nil {
+			return err
+		}
+		if err := revokeUserPersonalAccessTokens(tx, userID); err != nil {
+			return err
+		}
+		return tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", userID).
+			Update("used_at", time.Now()).Error
+	})
+}
+
+// メールアドレス変更関連のリポジトリ関数
+
+// メールアドレスが使われているか
+func emailExists(db *gorm.DB, email string) (bool, error) {
+	var count int64
+	err := db.Unscoped().Model(&User{}).Where("email = ?", email).Count(&count).Error
+	return count > 0, err
+}
+
+// メールアドレス変更の確認トークンを保存（同じユーザーの未使用の依頼は無効にする）
+func createEmailChangeRequest(db *gorm.DB, r *EmailChangeRequest) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&EmailChangeRequest{}).
+			Where("user_id = ? AND used_at IS NULL", r.UserID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(r).Error
+	})
+}
+
+// 確認トークンを消費してメールアドレスを切り替える
+// 同じアドレスへの変更が競合した場合は一意制約により片方だけが成功し、もう片方は errEmailTaken になる
+func consumeEmailChangeRequest(db *gorm.DB, tokenHash string) (*EmailChangeRequest, error) {
+	var r EmailChangeRequest
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+			First(&r).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvalidEmailChangeToken
+			}
+			return err
+		}
+		res := tx.Model(&EmailChangeRequest{}).
+			Where("id = ? AND used_at IS NULL", r.ID).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errInvalidEmailChangeToken
+		}
+		// 依頼後にメールアドレスが変わっていれば無効
+		res = tx.Model(&User{}).
+			Where("id = ? AND email = ?", r.UserID, r.OldEmail).
+			Updates(map[string]interface{}{"email": r.NewEmail, "verified_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errInvalidEmailChangeToken
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errInvalidEmailChangeToken) {
+		// 一意制約違反のエラーはドライバごとに異なるため、アドレスの使用状況で判定する
+		if taken, _ := emailExists(db, r.NewEmail); taken && r.NewEmail != "" {
+			return nil, errEmailTaken
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// 個人アクセストークン関連のリポジトリ関数
+
+// 個人アクセストークンを保存
+func createPersonalAccessToken(db *gorm.DB, t *PersonalAccessToken) error {
+	return db.Create(t).Error
+}
+
+// ハッシュから個人アクセストークンを取得
+func getPersonalAccessTokenByHash(db *gorm.DB, tokenHash string) (*PersonalAccessToken, error) {
+	var t PersonalAccessToken
+	if err := db.Where("token_hash = ?", tokenHash).First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ユーザーの個人アクセストークン一覧（新しい順）
+func listPersonalAccessTokens(db *gorm.DB, userID uint) ([]PersonalAccessToken, error) {
+	var tokens []PersonalAccessToken
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// 個人アクセストークンを失効し、失効したトークンを返す
+func revokePersonalAccessToken(db *gorm.DB, id uint, userID uint) (*PersonalAccessToken, error) {
+	res := db.Model(&PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var pat PersonalAccessToken
+	if err := db.First(&pat, id).Error; err != nil {
+		return nil, err
+	}
+	return &pat, nil
+}
+
+// 最終使用日時を更新（書き込みを減らすため 1 分以上経っている場合のみ）
+func touchPersonalAccessToken(db *gorm.DB, id uint, now time.Time) error {
+	return db.Model(&PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-time.Minute)).
+		Update("last_used_at", now).Error
+}
+
+// 二要素認証（TOTP）関連のリポジトリ関数
+
+// 登録中の TOTP シークレットを保存（有効化は confirm 時）
+func setUserTOTPSecret(db *gorm.DB, userID uint, secret string) error {
+	return db.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"totp_secret": secret, "totp_enabled": false, "totp_last_step": 0}).Error
+}
+
+// TOTP を有効化し、リカバリーコードを入れ替える
+func enableUserTOTP(db *gorm.DB, userID uint, step int64, codeHashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, len(codeHashes))
+		for i, h := range codeHashes {
+			codes[i] = RecoveryCode{UserID: userID, CodeHash: h}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// TOTP を無効化し、リカバリーコードを削除
+func disableUserTOTP(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false, "totp_last_step": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// 使用したタイムステップを記録（同じか古いステップは false = リプレイ）
+func markTOTPStepUsed(db *gorm.DB, userID uint, step int64) (bool, error) {
+	res := db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	return res.RowsAffected > 0, res.Error
+}
+
+// 未使用のリカバリーコード一覧
+func listUnusedRecoveryCodes(db *gorm.DB, userID uint) ([]RecoveryCode, error) {
+	var codes []RecoveryCode
+	err := db.Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error
+	return codes, err
+}
+
+// リカバリーコードを使用済みにする（既に使用済みなら false）
+func markRecoveryCodeUsed(db *gorm.DB, id uint) (bool, error) {
+	res := db.Model(&RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return res.RowsAffected > 0, res.Error
+}
+
+// ログイン試行制限関連のリポジトリ関数
+
+// ログイン失敗の記録を取得（記録がなければ nil）
+func getLoginAttempt(db *gorm.DB, kind, subject string) (*LoginAttempt, error) {
+	var a LoginAttempt
+	err := db.Where("kind = ? AND subject = ?", kind, subject).First(&a).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ログイン失敗を 1 回記録し、バックオフ・ロックを設定した結果を返す
+// 最後の失敗から policy.LockDuration 以上経っていればカウントをやり直す
+// カウントは UPDATE 文の中で加算するため、同時に失敗しても取りこぼさない
+func recordLoginFailure(db *gorm.DB, kind, subject string, maxFailures int, policy LoginThrottlePolicy, now time.Time) (*LoginAttempt, error) {
+	var a LoginAttempt
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&LoginAttempt{Kind: kind, Subject: subject}).Error; err != nil {
+			return err
+		}
+		windowStart := now.Add(-policy.LockDuration)
+		if err := tx.Model(&LoginAttempt{}).
+			Where("kind = ? AND subject = ?", kind, subject).
+			Updates(map[string]interface{}{
+				"failures":       gorm.Expr("CASE WHEN last_failed_at IS NULL OR last_failed_at < ? THEN 1 ELSE failures + 1 END", windowStart),
+				"last_failed_at": now,
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("kind = ? AND subject = ?", kind, subject).First(&a).Error; err != nil {
+			return err
+		}
+		next := now.Add(policy.Backoff(a.Failures))
+		a.NextAllowedAt = &next
+		if a.Failures >= maxFailures {
+			lockedUntil := now.Add(policy.LockDuration)
+			a.LockedUntil = &lockedUntil
+		}
+		return tx.Model(&a).Select("next_allowed_at", "locked_until").Updates(&a).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ログイン成功時に失敗の記録を削除
+func resetLoginAttempts(db *gorm.DB, kind, subject string) error {
+	return db.Where("kind = ? AND subject = ?", kind, subject).Delete(&LoginAttempt{}).Error
+}
+
+// ログイン失敗の記録一覧（管理者向け、失敗回数の多い順）
+func listLoginAttempts(db *gorm.DB, kind string, lockedOnly bool, now time.Time, limit, offset int) ([]LoginAttempt, error) {
+	q := db.Model(&LoginAttempt{})
+	if kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	if lockedOnly {
+		q = q.Where("locked_until > ?", now)
+	}
+	var attempts []LoginAttempt
+	err := q.Order("failures DESC, last_failed_at DESC").Limit(limit).Offset(offset).Find(&attempts).Error
+	return attempts, err
+}
+
+// ログイン失敗の記録を ID で削除（管理者によるロック解除）、削除した記録を返す
+func deleteLoginAttempt(db *gorm.DB, id uint) (*LoginAttempt, error) {
+	var a LoginAttempt
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&a, id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&a).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// 退会（アカウント削除）関連のリポジトリ関数
+
+// 退会した利用者の投稿の表示名（匿名化ポリシー）
+const deletedUserDisplayName = "退会したユーザー"
+
+// 退会を予約する（猶予期間の終了時刻 at に完全削除される）
+func scheduleUserDeletion(db *gorm.DB, userID uint, at time.Time) error {
+	res := db.Model(&User{}).
+		Where("id = ? AND deletion_scheduled_at IS NULL", userID).
+		Update("deletion_scheduled_at", at)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// 退会の予約を取り消す（猶予期間を過ぎていれば false）
+func cancelUserDeletion(db *gorm.DB, userID uint, now time.Time) (bool, error) {
+	res := db.Model(&User{}).
+		Where("id = ? AND deletion_scheduled_at > ?", userID, now).
+		Update("deletion_scheduled_at", nil)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ユーザーの個人アクセストークンをすべて失効
+func revokeUserPersonalAccessTokens(db *gorm.DB, userID uint) error {
+	return db.Model(&PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// 猶予期間を過ぎた退会予約ユーザーの ID 一覧
+func listUsersDueForPurge(db *gorm.DB, now time.Time) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&User{}).Where("deletion_scheduled_at <= ?", now).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ユーザーと所有データを完全に削除する
+// 投稿は postPolicy に従い匿名化または削除し、影響を受けた投稿のいいね数・コメント数を数え直す
+func purgeUser(db *gorm.DB, userID uint, postPolicy string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var u User
+		if err := tx.Unscoped().First(&u, userID).Error; err != nil {
+			return err
+		}
+
+		// いいね・コメントした他人の投稿はカウントを数え直す
+		var affected []uint
+		if err := tx.Model(&Like{}).Where("user_id = ?", userID).Distinct().Pluck("post_id", &affected).Error; err != nil {
+			return err
+		}
+		var commented []uint
+		if err := tx.Model(&Comment{}).Where("user_id = ?", userID).Distinct().Pluck("post_id", &commented).Error; err != nil {
+			return err
+		}
+		affected = append(affected, commented...)
+
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&Like{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&Comment{}).Error; err != nil {
+			return err
+		}
+
+		// 投稿
+		if postPolicy == PostPolicyDelete {
+			own := tx.Unscoped().Model(&Post{}).Select("id").Where("user_id = ?", userID)
+			if err := tx.Unscoped().Where("post_id IN (?)", own).Delete(&Like{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("post_id IN (?)", own).Delete(&Comment{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&Post{}).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Unscoped().Model(&Post{}).Where("user_id = ?", userID).
+				Updates(map[string]interface{}{"user_id": 0, "display_name": deletedUserDisplayName}).Error; err != nil {
+				return err
+			}
+		}
+		if len(affected) > 0 {
+			if err := tx.Unscoped().Model(&Post{}).Where("id IN ?", affected).Updates(map[string]interface{}{
+				"like_count":    gorm.Expr("(SELECT COUNT(*) FROM likes WHERE likes.post_id = posts.id AND likes.deleted_at IS NULL)"),
+				"comment_count": gorm.Expr("(SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted_at IS NULL)"),
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		// タグの付け外し（タグはユーザーごとなので、タグの所有者で削除する）
+		for _, table := range []string{"company_list_tags", "internship_tags"} {
+			if err := tx.Exec("DELETE FROM "+table+" WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ?)", userID).Error; err != nil {
+				return err
+			}
+		}
+
+		// 予定のリマインダー（予定の所有者で削除する）
+		events := tx.Unscoped().Model(&Event{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("event_id IN (?)", events).Delete(&EventReminder{}).Error; err != nil {
+			return err
+		}
+
+		// 就活データと認証関連のデータ
+		for _, model := range []interface{}{
+			&CompanyList{}, &Internship{}, &SelectionStage{}, &StageChange{}, &Tag{}, &Note{}, &Contact{}, &ContactInteraction{}, &Event{},
+			&RecoveryCode{}, &Session{}, &RefreshToken{}, &RevokedToken{}, &PasswordResetToken{}, &EmailChangeRequest{}, &PersonalAccessToken{},
+			&ExportJob{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("kind = ? AND subject = ?", loginKindEmail, strings.ToLower(u.Email)).Delete(&LoginAttempt{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&u).Error
+	})
+}
+
+// 個人データのエクスポート関連のリポジトリ関数
+
+// ユーザーの投稿一覧
+func listPostsByUser(db *gorm.DB, userID uint) ([]Post, error) {
+	var posts []Post
+	err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&posts).Error
+	return posts, err
+}
+
+// ユーザーのコメント一覧
+func listCommentsByUser(db *gorm.DB, userID uint) ([]Comment, error) {
+	var comments []Comment
+	err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&comments).Error
+	return comments, err
+}
+
+// ユーザーのいいね一覧
+func listLikesByUser(db *gorm.DB, userID uint) ([]Like, error) {
+	var likes []Like
+	err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&likes).Error
+	return likes, err
+}
+
+// エクスポート対象のレコード数（同期で返すか非同期ジョブにするかの判定に使う）
+// writeUserExport が書き出すデータセットをすべて数える（予定のリマインダーを含む）
+func countUserExportRecords(db *gorm.DB, userID uint) (int64, error) {
+	var total int64
+	for _, model := range []interface{}{
+		&CompanyList{}, &Internship{}, &Post{}, &Comment{}, &Like{},
+		&StageChange{}, &Tag{}, &Note{}, &Contact{}, &ContactInteraction{}, &Event{},
+	} {
+		var count int64
+		if err := db.Model(model).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	var reminders int64
+	events := db.Model(&Event{}).Select("id").Where("user_id = ?", userID)
+	if err := db.Model(&EventReminder{}).Where("event_id IN (?)", events).Count(&reminders).Error; err != nil {
+		return 0, err
+	}
+	return total + reminders, nil
+}
+
+// エクスポートジョブを作成
+func createExportJob(db *gorm.DB, job *ExportJob) error {
+	return db.Create(job).Error
+}
+
+// 実行待ち・実行中のエクスポートジョブを取得（なければ nil）
+func getActiveExportJob(db *gorm.DB, userID uint) (*ExportJob, error) {
+	var job ExportJob
+	err := db.Where("user_id = ? AND status IN ?", userID, []string{ExportStatusPending, ExportStatusRunning}).
+		Order("id DESC").First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// エクスポートジョブを取得（本人のもののみ）
+func getExportJob(db *gorm.DB, id uint, userID uint) (*ExportJob, error) {
+	var job ExportJob
+	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// エクスポートジョブを実行中にする
+func startExportJob(db *gorm.DB, id uint) error {
+	return db.Model(&ExportJob{}).Where("id = ? AND status = ?", id, ExportStatusPending).
+		Update("status", ExportStatusRunning).Error
+}
+
+// エクスポートジョブを完了または失敗にする
+func finishExportJob(db *gorm.DB, id uint, status, errMsg, path string, size int64, expiresAt time.Time) error {
+	return db.Model(&ExportJob{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":       status,
+		"error":        errMsg,
+		"file_path":    path,
+		"size":         size,
+		"completed_at": time.Now(),
+		"expires_at":   expiresAt,
+	}).Error
+}
+
+// サーバー再起動で中断されたエクスポートジョブを失敗にする
+func failInterruptedExportJobs(db *gorm.DB) error {
+	return db.Model(&ExportJob{}).
+		Where("status IN ?", []string{ExportStatusPending, ExportStatusRunning}).
+		Updates(map[string]interface{}{"status": ExportStatusFailed, "error": "interrupted"}).Error
+}
+
+// 期限切れのエクスポートジョブを削除
+func deleteExpiredExportJobs(db *gorm.DB, now time.Time) error {
+	return db.Unscoped().Where("expires_at < ?", now).Delete(&ExportJob{}).Error
+}
+
+// 監査ログ関連のリポジトリ関数
+
+// 監査ログの検索条件（ゼロ値の項目は絞り込まない）
+type AuditEventFilter struct {
+	UserID uint
+	Types  []string
+	Result string
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// 監査ログを追記
+func createAuditEvent(db *gorm.DB, e *AuditEvent) error {
+	return db.Create(e).Error
+}
+
+// 監査ログを新しい順に検索
+func listAuditEvents(db *gorm.DB, f AuditEventFilter) ([]AuditEvent, error) {
+	q := db.Model(&AuditEvent{})
+	if f.UserID != 0 {
+		q = q.Where("user_id = ?", f.UserID)
+	}
+	if len(f.Types) > 0 {
+		q = q.Where("type IN ?", f.Types)
+	}
+	if f.Result != "" {
+		q = q.Where("result = ?", f.Result)
+	}
+	if f.From != nil {
+		q = q.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("created_at < ?", *f.To)
+	}
+	var events []AuditEvent
+	err := q.Order("created_at DESC, id DESC").Limit(f.Limit).Offset(f.Offset).Find(&events).Error
+	return events, err
+}
+
+// 選考ステージ関連のリポジトリ関数
+
+// 独自ステージの一覧
+func listSelectionStages(db *gorm.DB, userID uint) ([]SelectionStage, error) {
+	var stages []SelectionStage
+	err := db.Where("user_id = ?", userID).Order("id ASC").Find(&stages).Error
+	return stages, err
+}
+
+// 独自ステージを取得（本人のもののみ）
+func getSelectionStage(db *gorm.DB, id uint, userID uint) (*SelectionStage, error) {
+	var s SelectionStage
+	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&s).Error; err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// 独自ステージを作成
+func createSelectionStage(db *gorm.DB, s *SelectionStage) error {
+	return db.Create(s).Error
+}
+
+// 独自ステージを削除（使用中なら errStageInUse）、削除したステージを返す
+func deleteSelectionStage(db *gorm.DB, id uint, userID uint) (*SelectionStage, error) {
+	var s *SelectionStage
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if s, err = getSelectionStage(tx, id, userID); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&CompanyList{}, &Internship{}} {
+			var count int64
+			if err := tx.Model(model).Where("user_id = ? AND stage = ?", userID, s.Key()).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errStageInUse
+			}
+		}
+		return tx.Delete(s).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ステージごとの件数
+type stageCount struct {
+	Stage string
+	Count int64
+}
+
+// CompanyList / インターンシップのステージごとの件数（model で対象を選ぶ）
+func countByStage(db *gorm.DB, model interface{}, userID uint) ([]stageCount, error) {
+	var counts []stageCount
+	err := db.Model(model).Select("stage, COUNT(*) AS count").
+		Where("user_id = ?", userID).Group("stage").Scan(&counts).Error
+	return counts, err
+}
+
+// 選考タイムライン関連のリポジトリ関数
+
+// ステージの変更を記録
+func createStageChange(db *gorm.DB, sc *StageChange) error {
+	return db.Create(sc).Error
+}
+
+// CompanyList のステージ変更履歴（古い順）
+func listStageChanges(db *gorm.DB, companyListID uint, userID uint) ([]StageChange, error) {
+	var changes []StageChange
+	err := db.Where("company_list_id = ? AND user_id = ?", companyListID, userID).
+		Order("changed_at ASC, id ASC").Find(&changes).Error
+	return changes, err
+}
+
+// ユーザーの全ステージ変更履歴（エクスポート用）
+func listStageChangesByUser(db *gorm.DB, userID uint) ([]StageChange, error) {
+	var changes []StageChange
+	err := db.Where("user_id = ?", userID).Order("company_list_id ASC, changed_at ASC").Find(&changes).Error
+	return changes, err
+}
+
+// 履歴の日時とメモを修正（後からの記録・訂正用）
+func updateStageChange(db *gorm.DB, id uint, companyListID uint, userID uint, changedAt *time.Time, note *string) (*StageChange, error) {
+	updates := map[string]interface{}{}
+	if changedAt != nil {
+		updates["changed_at"] = *changedAt
+	}
+	if note != nil {
+		updates["note"] = *note
+	}
+	var sc StageChange
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND company_list_id = ? AND user_id = ?", id, companyListID, userID).First(&sc).Error; err != nil {
+			return err
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&sc).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// 一覧の絞り込み・並び替え・ページング
+
+// CompanyList の並び替えに使える項目
+var companyListSortFields = map[string]sortField{
+	"id":         {Column: "id"},
+	"company":    {Column: "company"},
+	"occupation": {Column: "occupation"},
+	"member":     {Column: "member"},
+	"intern":     {Column: "intern"},
+	"rank":       {Column: "rank"},
+	"created_at": {Column: "created_at", Time: true},
+	"updated_at": {Column: "updated_at", Time: true},
+}
+
+// Internship の並び替えに使える項目
+var internshipSortFields = map[string]sortField{
+	"id":          {Column: "id"},
+	"title":       {Column: "title"},
+	"company":     {Column: "company"},
+	"dailystart":  {Column: "dailystart"},
+	"dailyfinish": {Column: "dailyfinish"},
+	"joined":      {Column: "joined"},
+	"starts_on":   {Column: "starts_on"},
+	"created_at":  {Column: "created_at", Time: true},
+	"updated_at":  {Column: "updated_at", Time: true},
+}
+
+// sortValues は次のページのカーソルに入れる並び替えキーの値を返します
+func (l CompanyList) sortValues(keys []sortKey) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		switch k.Name {
+		case "id":
+			values[i] = l.ID
+		case "company":
+			values[i] = l.Company
+		case "occupation":
+			values[i] = l.Occupation
+		case "member":
+			values[i] = l.Member
+		case "intern":
+			values[i] = l.Intern
+		case "rank":
+			values[i] = l.Rank
+		case "created_at":
+			values[i] = l.CreatedAt
+		case "updated_at":
+			values[i] = l.UpdatedAt
+		}
+	}
+	return values
+}
+
+// sortValues は次のページのカーソルに入れる並び替えキーの値を返します
+func (in Internship) sortValues(keys []sortKey) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		switch k.Name {
+		case "id":
+			values[i] = in.ID
+		case "title":
+			values[i] = in.Title
+		case "company":
+			values[i] = in.Company
+		case "dailystart":
+			values[i] = in.Dailystart
+		case "dailyfinish":
+			values[i] = in.Dailyfinish
+		case "joined":
+			values[i] = in.Joined
+		case "starts_on":
+			values[i] = in.StartsOn
+		case "created_at":
+			values[i] = in.CreatedAt
+		case "updated_at":
+			values[i] = in.UpdatedAt
+		}
+	}
+	return values
+}
+
+// 条件に合う CompanyList の 1 ページと総件数を取得
+// more は次のページがあるかどうか
+func queryCompanyLists(db *gorm.DB, userID uint, q ListQuery) (lists []CompanyList, total int64, more bool, err error) {
+	if err = q.filtered(db.Model(&CompanyList{}).Where("user_id = ?", userID)).Count(&total).Error; err != nil {
+		return nil, 0, false, err
+	}
+	if err = q.paged(preloadTags(db).Where("user_id = ?", userID)).Find(&lists).Error; err != nil {
+		return nil, 0, false, err
+	}
+	if q.Limit > 0 && len(lists) > q.Limit {
+		lists, more = lists[:q.Limit], true
+	}
+	return lists, total, more, nil
+}
+
+// 条件に合う Internship の 1 ページと総件数を取得
+// more は次のページがあるかどうか
+func queryInternships(db *gorm.DB, userID uint, q ListQuery) (internships []Internship, total int64, more bool, err error) {
+	if err = q.filtered(db.Model(&Internship{}).Where("user_id = ?", userID)).Count(&total).Error; err != nil {
+		return nil, 0, false, err
+	}
+	if err = q.paged(preloadTags(db).Where("user_id = ?", userID)).Find(&internships).Error; err != nil {
+		return nil, 0, false, err
+	}
+	if q.Limit > 0 && len(internships) > q.Limit {
+		internships, more = internships[:q.Limit], true
+	}
+	return internships, total, more, nil
+}
+
+// タグ関連のリポジトリ関数
+
+// タグの結合テーブル（タグの統合・削除で使う）
+var tagJoinTables = []struct {
+	Table  string
+	Column string
+}{
+	{"company_list_tags", "company_list_id"},
+	{"internship_tags", "internship_id"},
+}
+
+// preloadTags は CompanyList・Internship の取得時にタグ（名前順）を読み込みます
+func preloadTags(db *gorm.DB) *gorm.DB {
+	return db.Preload("Tags", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("tags.name ASC")
+	})
+}
+
+// ユーザーのタグ一覧（名前順）
+func listTags(db *gorm.DB, userID uint) ([]Tag, error) {
+	var tags []Tag
+	err := db.Where("user_id = ?", userID).Order("name ASC").Find(&tags).Error
+	return tags, err
+}
+
+// ユーザーのタグを 1 件取得
+func getTag(db *gorm.DB, id uint, userID uint) (*Tag, error) {
+	var t Tag
+	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ID を指定してユーザーのタグを取得（1 つでも見つからなければ errUnknownTag）
+func getTagsByIDs(db *gorm.DB, userID uint, ids []uint) ([]Tag, error) {
+	unique := map[uint]bool{}
+	for _, id := range ids {
+		unique[id] = true
+	}
+	var tags []Tag
+	if len(unique) == 0 {
+		return tags, nil
+	}
+	if err := db.Where("user_id = ? AND id IN ?", userID, ids).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	if len(tags) != len(unique) {
+		return nil, errUnknownTag
+	}
+	return tags, nil
+}
+
+// 同じ名前のタグがあるか（excludeID のタグは除く）
+func tagNameExists(db *gorm.DB, userID uint, name string, excludeID uint) (bool, error) {
+	var count int64
+	err := db.Model(&Tag{}).Where("user_id = ? AND name = ? AND id <> ?", userID, name, excludeID).Count(&count).Error
+	return count > 0, err
+}
+
+// タグを作成（同じ名前があれば errTagNameTaken）
+func createTag(db *gorm.DB, t *Tag) error {
+	if taken, err := tagNameExists(db, t.UserID, t.Name, 0); err != nil {
+		return err
+	} else if taken {
+		return errTagNameTaken
+	}
+	if err := db.Create(t).Error; err != nil {
+		// 同時に作成された場合は一意制約で失敗する
+		if taken, _ := tagNameExists(db, t.UserID, t.Name, 0); taken {
+			return errTagNameTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// タグの名前と色を変更し、変更後のタグを返す
+func updateTag(db *gorm.DB, id uint, userID uint, name, color string) (*Tag, error) {
+	if taken, err := tagNameExists(db, userID, name, id); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, errTagNameTaken
+	}
+	res := db.Model(&Tag{}).Where("id = ? AND user_id = ?", id, userID).
+		Updates(map[string]interface{}{"name": name, "color": color})
+	if res.Error != nil {
+		if taken, _ := tagNameExists(db, userID, name, id); taken {
+			return nil, errTagNameTaken
+		}
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return getTag(db, id, userID)
+}
+
+// タグを削除し（付けてあるレコードからも外す）、削除したタグを返す
+func deleteTag(db *gorm.DB, id uint, userID uint) (*Tag, error) {
+	var t Tag
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&t).Error; err != nil {
+			return err
+		}
+		for _, j := range tagJoinTables {
+			if err := tx.Exec("DELETE FROM "+j.Table+" WHERE tag_id = ?", t.ID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&t).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// タグ sourceID を targetID に統合する
+// source が付いていたレコードには target を付け（重複はしない）、source は削除する
+func mergeTags(db *gorm.DB, sourceID, targetID uint, userID uint) (*Tag, error) {
+	var target Tag
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var source Tag
+		if err := tx.Where("id = ? AND user_id = ?", sourceID, userID).First(&source).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ? AND user_id = ?", targetID, userID).First(&target).Error; err != nil {
+			return errUnknownTag
+		}
+		for _, j := range tagJoinTables {
+			if err := tx.Exec(
+				"INSERT INTO "+j.Table+" ("+j.Column+", tag_id) SELECT "+j.Column+", ? FROM "+j.Table+
+					" WHERE tag_id = ? AND "+j.Column+" NOT IN (SELECT "+j.Column+" FROM "+j.Table+" WHERE tag_id = ?)",
+				target.ID, source.ID, target.ID,
+			).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("DELETE FROM "+j.Table+" WHERE tag_id = ?", source.ID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&source).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// CompanyList のタグを tags に置き換える（空ならすべて外す）
+func setCompanyListTags(db *gorm.DB, cl *CompanyList, tags []Tag) error {
+	if len(tags) == 0 {
+		return db.Model(cl).Association("Tags").Clear()
+	}
+	return db.Model(cl).Association("Tags").Replace(tags)
+}
+
+// インターンシップのタグを tags に置き換える（空ならすべて外す）
+func setInternshipTags(db *gorm.DB, in *Internship, tags []Tag) error {
+	if len(tags) == 0 {
+		return db.Model(in).Association("Tags").Clear()
+	}
+	return db.Model(in).Association("Tags").Replace(tags)
+}
+
+// 志望順位関連のリポジトリ関数
+
+// CompanyList の id を anchorID の直前（before=true）または直後に移動し、移動後のレコードを返す
+// 前後の順位に間がなければ、そのユーザーの順位を振り直してから移動する（rebalanced=true）
+// 同じユーザーの並べ替えは users の行ロックで直列化する（SQLite は書き込みがもともと直列）
+func moveCompanyList(db *gorm.DB, userID, id, anchorID uint, before bool) (cl *CompanyList, rebalanced bool, err error) {
+	var moved CompanyList
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var u User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&u, userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&moved).Error; err != nil {
+			return err
+		}
+		rank, ok, err := rankNextTo(tx, userID, moved.ID, anchorID, before)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := rebalanceCompanyRanks(tx, userID); err != nil {
+				return err
+			}
+			rebalanced = true
+			if rank, ok, err = rankNextTo(tx, userID, moved.ID, anchorID, before); err != nil {
+				return err
+			}
+			if !ok {
+				return errors.New("no rank available after rebalancing")
+			}
+		}
+		return tx.Model(&moved).Update("rank", rank).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	cl, err = getCompanyList(db, id, userID)
+	return cl, rebalanced, err
+}
+
+// rankNextTo は anchorID の直前・直後に入れる順位を返す（movedID のレコードは除いて考える）
+// 隣のレコードとの間がなければ ok=false
+func rankNextTo(tx *gorm.DB, userID, movedID, anchorID uint, before bool) (int64, bool, error) {
+	var anchor CompanyList
+	if err := tx.Where("id = ? AND user_id = ?", anchorID, userID).First(&anchor).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, false, errUnknownRankAnchor
+		}
+		return 0, false, err
+	}
+	// 並び順は (rank, id)
+	q := tx.Where("user_id = ? AND id <> ?", userID, movedID)
+	var neighbor CompanyList
+	var err error
+	if before {
+		err = q.Where("rank < ? OR (rank = ? AND id < ?)", anchor.Rank, anchor.Rank, anchor.ID).
+			Order("rank DESC, id DESC").First(&neighbor).Error
+	} else {
+		err = q.Where("rank > ? OR (rank = ? AND id > ?)", anchor.Rank, anchor.Rank, anchor.ID).
+			Order("rank ASC, id ASC").First(&neighbor).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 先頭・末尾への移動
+		if before {
+			return anchor.Rank - rankGap, true, nil
+		}
+		return anchor.Rank + rankGap, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if before {
+		rank, ok := rankBetween(neighbor.Rank, anchor.Rank)
+		return rank, ok, nil
+	}
+	rank, ok := rankBetween(anchor.Rank, neighbor.Rank)
+	return rank, ok, nil
+}
+
+// ユーザーの CompanyList の順位を、今の並び順のまま rankGap 間隔で振り直す
+func rebalanceCompanyRanks(tx *gorm.DB, userID uint) error {
+	var ids []uint
+	if err := tx.Unscoped().Model(&CompanyList{}).Where("user_id = ?", userID).
+		Order("rank ASC, id ASC").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if err := tx.Unscoped().Model(&CompanyList{}).Where("id = ?", id).
+			UpdateColumn("rank", int64(i+1)*rankGap).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ノート関連のリポジトリ関数
+
+// ノートの並び替えに使える項目
+var noteSortFields = map[string]sortField{
+	"id":         {Column: "id"},
+	"type":       {Column: "type"},
+	"title":      {Column: "title"},
+	"created_at": {Column: "created_at", Time: true},
+	"updated_at": {Column: "updated_at", Time: true},
+}
+
+// sortValues は次のページのカーソルに入れる並び替えキーの値を返します
+func (n Note) sortValues(keys []sortKey) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		switch k.Name {
+		case "id":
+			values[i] = n.ID
+		case "type":
+			values[i] = n.Type
+		case "title":
+			values[i] = n.Title
+		case "created_at":
+			values[i] = n.CreatedAt
+		case "updated_at":
+			values[i] = n.UpdatedAt
+		}
+	}
+	return values
+}
+
+// ノートを作成
+func createNote(db *gorm.DB, n *Note) error {
+	return db.Create(n).Error
+}
+
+// CompanyList のノートを 1 件取得
+func getNote(db *gorm.DB, id uint, companyListID uint, userID uint) (*Note, error) {
+	var n Note
+	if err := db.Where("id = ? AND company_list_id = ? AND user_id = ?", id, companyListID, userID).First(&n).Error; err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// ノートを更新し、更新後のノートを返す（空の本文・ステージも更新する）
+func updateNote(db *gorm.DB, id uint, companyListID uint, userID uint, noteType, title, body, stage string) (*Note, error) {
+	res := db.Model(&Note{}).Where("id = ? AND company_list_id = ? AND user_id = ?", id, companyListID, userID).
+		Updates(map[string]interface{}{"type": noteType, "title": title, "body": body, "stage": stage})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return getNote(db, id, companyListID, userID)
+}
+
+// ノートを削除し、削除したノートを返す
+func deleteNote(db *gorm.DB, id uint, companyListID uint, userID uint) (*Note, error) {
+	var n Note
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND company_list_id = ? AND user_id = ?", id, companyListID, userID).First(&n).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&n).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// 条件に合うノートの 1 ページと総件数を取得（削除済みの CompanyList のノートは含めない）
+// more は次のページがあるかどうか
+func queryNotes(db *gorm.DB, userID uint, q ListQuery) (notes []Note, total int64, more bool, err error) {
+	live := db.Model(&CompanyList{}).Select("id").Where("user_id = ?", userID)
+	if err = q.filtered(db.Model(&Note{}).Where("user_id = ? AND company_list_id IN (?)", userID, live)).Count(&total).Error; err != nil {
+		return nil, 0, false, err
+	}
+	if err = q.paged(db.Where("user_id = ? AND company_list_id IN (?)", userID, live)).Find(&notes).Error; err != nil {
+		return nil, 0, false, err
+	}
+	if q.Limit > 0 && len(notes) > q.Limit {
+		notes, more = notes[:q.Limit], true
+	}
+	return notes, total, more, nil
+}
+
+// ユーザーの全ノート（エクスポート用）
+func listNotesByUser(db *gorm.DB, userID uint) ([]Note, error) {
+	var notes []Note
+	err := db.Where("user_id = ?", userID).Order("company_list_id ASC, created_at ASC").Find(&notes).Error
+	return notes, err
+}
+
+// ID を指定して企業名を取得（ノートの検索結果用）
+func companyNamesByIDs(db *gorm.DB, userID uint, ids []uint) (map[uint]string, error) {
+	var lists []CompanyList
+	if err := db.Select("id", "company").Where("user_id = ? AND id IN ?", userID, ids).Find(&lists).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(lists))
+	for _, l := range lists {
+		names[l.ID] = l.Company
+	}
+	return names, nil
+}
+
+// 連絡先関連のリポジトリ関数
+
+// lastContactOrCreated は最終連絡日時を返します（未連絡なら登録日時）
+func (ct Contact) lastContactOrCreated() time.Time {
+	if ct.LastContactedAt != nil {
+		return *ct.LastContactedAt
+	}
+	return ct.CreatedAt
+}
+
+// CompanyList の連絡先一覧
+func listContacts(db *gorm.DB, companyListID uint, userID uint) ([]Contact, error) {
+	var contacts []Contact
+	err := db.Where("company_list_id = ? AND user_id = ?", companyListID, userID).Order("name ASC, id ASC").Find(&contacts).Error
+	return contacts, err
+}
+
+// 連絡先を 1 件取得
+func getContact(db *gorm.DB, id uint, companyListID uint, userID uint) (*Contact, error) {
+	var ct Contact
+	if err := db.Where("id = ? AND company_list_id = ? AND user_id = ?", id, companyListID, userID).First(&ct).Error; err != nil {
+		return nil, err
+	}
+	return &ct, nil
+}
+
+// 連絡先を作成
+func createContact(db *gorm.DB, ct *Contact) error {
+	return db.Create(ct).Error
+}
+
+// 連絡先を更新し、更新後の連絡先を返す（空の値も更新する）
+// 最終連絡日時はやり取りの最新の日時より前にしない（省略された場合もやり取りから求める）
+func updateContact(db *gorm.DB, id uint, companyListID uint, userID uint, ct *Contact) (*Contact, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var latest ContactInteraction
+		err := tx.Where("contact_id = ? AND user_id = ?", id, userID).Order("occurred_at DESC").First(&latest).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && (ct.LastContactedAt == nil || ct.LastContactedAt.Before(latest.OccurredAt)) {
+			t := latest.OccurredAt.UTC()
+			ct.LastContactedAt = &t
+		}
+		res := tx.Model(&Contact{}).Where("id = ? AND company_list_id = ? AND user_id = ?", id, companyListID, userID).
+			Select("name", "role", "email", "phone", "last_contacted_at", "notes").
+			Updates(ct)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getContact(db, id, companyListID, userID)
+}
+
+// 連絡先とそのやり取りを削除し、削除した連絡先を返す
+func deleteContact(db *gorm.DB, id uint, companyListID uint, userID uint) (*Contact, error) {
+	var ct Contact
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND company_list_id = ? AND user_id = ?", id, companyListID, userID).First(&ct).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("contact_id = ?", ct.ID).Delete(&ContactInteraction{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&ct).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ct, nil
+}
+
+// 連絡先のやり取りを新しい順に取得
+func listContactInteractions(db *gorm.DB, contactID uint, userID uint) ([]ContactInteraction, error) {
+	var interactions []ContactInteraction
+	err := db.Where("contact_id = ? AND user_id = ?", contactID, userID).Order("occurred_at DESC, id DESC").Find(&interactions).Error
+	return interactions, err
+}
+
+// やり取りを記録し、連絡先の最終連絡日時を進める（古いやり取りを後から記録した場合は変えない）
+func createContactInteraction(db *gorm.DB, in *ContactInteraction) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(in).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Contact{}).
+			Where("id = ? AND (last_contacted_at IS NULL OR last_contacted_at < ?)", in.ContactID, in.OccurredAt).
+			Update("last_contacted_at", in.OccurredAt).Error
+	})
+}
+
+// やり取りを削除し、削除したやり取りを返す
+// 最終連絡日時がこのやり取りの日時だった場合は、残りのやり取りの最新の日時に戻す
+func deleteContactInteraction(db *gorm.DB, id uint, contactID uint, userID uint) (*ContactInteraction, error) {
+	var in ContactInteraction
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND contact_id = ? AND user_id = ?", id, contactID, userID).First(&in).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&in).Error; err != nil {
+			return err
+		}
+		var ct Contact
+		if err := tx.First(&ct, contactID).Error; err != nil {
+			return err
+		}
+		if ct.LastContactedAt == nil || !ct.LastContactedAt.Equal(in.OccurredAt) {
+			return nil
+		}
+		var latest ContactInteraction
+		err := tx.Where("contact_id = ?", contactID).Order("occurred_at DESC").First(&latest).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Model(&ct).Update("last_contacted_at", nil).Error
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&ct).Update("last_contacted_at", latest.OccurredAt).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &in, nil
+}
+
+// フォローアップが必要な連絡先を取得（最終連絡が before より前、未連絡なら登録が before より前）
+// 削除済み・選考が終わった CompanyList の連絡先は含めない。最終連絡が古い順
+// last_contacted_at は UTC、created_at はサーバーのタイムゾーンで保存しているため、それぞれに合わせて比べる
+func listDueContacts(db *gorm.DB, userID uint, before time.Time) ([]Contact, error) {
+	open := db.Model(&CompanyList{}).Select("id").
+		Where("user_id = ? AND (stage IS NULL OR stage NOT IN ?)", userID, []string{StageAccepted, StageDeclined, StageRejected})
+	var contacts []Contact
+	err := db.Where("user_id = ? AND company_list_id IN (?)", userID, open).
+		Where("(last_contacted_at IS NOT NULL AND last_contacted_at < ?) OR (last_contacted_at IS NULL AND created_at < ?)", before.UTC(), before.Local()).
+		Find(&contacts).Error
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(contacts, func(i, j int) bool {
+		a, b := contacts[i].lastContactOrCreated(), contacts[j].lastContactOrCreated()
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return contacts[i].ID < contacts[j].ID
+	})
+	return contacts, nil
+}
+
+// ユーザーの全連絡先（エクスポート用）
+func listContactsByUser(db *gorm.DB, userID uint) ([]Contact, error) {
+	var contacts []Contact
+	err := db.Where("user_id = ?", userID).Order("company_list_id ASC, id ASC").Find(&contacts).Error
+	return contacts, err
+}
+
+// ユーザーの全やり取り（エクスポート用）
+func listContactInteractionsByUser(db *gorm.DB, userID uint) ([]ContactInteraction, error) {
+	var interactions []ContactInteraction
+	err := db.Where("user_id = ?", userID).Order("contact_id ASC, occurred_at ASC").Find(&interactions).Error
+	return interactions, err
+}
+
+// 予定関連のリポジトリ関数
+
+// 予定の並び替えに使える項目
+var eventSortFields = map[string]sortField{
+	"id":         {Column: "id"},
+	"type":       {Column: "type"},
+	"title":      {Column: "title"},
+	"starts_at":  {Column: "starts_at", Time: true, UTC: true},
+	"created_at": {Column: "created_at", Time: true},
+	"updated_at": {Column: "updated_at", Time: true},
+}
+
+// sortValues は次のページのカーソルに入れる並び替えキーの値を返します
+func (e Event) sortValues(keys []sortKey) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		switch k.Name {
+		case "id":
+			values[i] = e.ID
+		case "type":
+			values[i] = e.Type
+		case "title":
+			values[i] = e.Title
+		case "starts_at":
+			values[i] = e.StartsAt
+		case "created_at":
+			values[i] = e.CreatedAt
+		case "updated_at":
+			values[i] = e.UpdatedAt
+		}
+	}
+	return values
+}
+
+// liveEvents は削除されていない CompanyList・Internship に紐づくユーザーの予定に絞り込むスコープです
+func liveEvents(db *gorm.DB, userID uint) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		lists := db.Model(&CompanyList{}).Select("id").Where("user_id = ?", userID)
+		internships := db.Model(&Internship{}).Select("id").Where("user_id = ?", userID)
+		return tx.Where("events.user_id = ? AND (company_list_id IN (?) OR internship_id IN (?))", userID, lists, internships)
+	}
+}
+
+// newEventReminders は開始時刻と分数からリマインダーを作ります
+func newEventReminders(startsAt time.Time, minutes []int) []EventReminder {
+	reminders := make([]EventReminder, 0, len(minutes))
+	for _, m := range minutes {
+		reminders = append(reminders, EventReminder{
+			MinutesBefore: m,
+			RemindAt:      startsAt.Add(-time.Duration(m) * time.Minute),
+		})
+	}
+	return reminders
+}
+
+// deleteLinkedEvents は CompanyList・Internship（column = id）に紐づく予定とリマインダーを削除します
+func deleteLinkedEvents(tx *gorm.DB, column string, id uint) error {
+	events := tx.Model(&Event{}).Select("id").Where(column+" = ?", id)
+	if err := tx.Where("event_id IN (?)", events).Delete(&EventReminder{}).Error; err != nil {
+		return err
+	}
+	return tx.Where(column+" = ?", id).Delete(&Event{}).Error
+}
+
+// 予定をリマインダーとともに作成
+func createEvent(db *gorm.DB, e *Event) error {
+	return db.Create(e).Error
+}
+
+// 予定をリマインダーとともに 1 件取得
+func getEvent(db *gorm.DB, id uint, userID uint) (*Event, error) {
+	var e Event
+	if err := db.Preload("Reminders", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("remind_at ASC")
+	}).Scopes(liveEvents(db, userID)).Where("events.id = ?", id).First(&e).Error; err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// 予定を更新し、リマインダーを作り直す
+// 通知時刻が変わらないリマインダーはそのまま残し（送信済みなら再送しない）、時刻が変わったものは新しい時刻で送り直す
+func updateEvent(db *gorm.DB, id uint, userID uint, e *Event, minutes []int) (*Event, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Event{}).Where("id = ? AND user_id = ?", id, userID).
+			Select("company_list_id", "internship_id", "type", "title", "starts_at", "ends_at", "time_zone", "location", "online_url").
+			Updates(e)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		var existing []EventReminder
+		if err := tx.Where("event_id = ?", id).Find(&existing).Error; err != nil {
+			return err
+		}
+		wanted := map[int]bool{}
+		for _, m := range minutes {
+			wanted[m] = true
+		}
+		kept := map[int]bool{}
+		var stale []uint
+		for _, r := range existing {
+			remindAt := e.StartsAt.Add(-time.Duration(r.MinutesBefore) * time.Minute)
+			if wanted[r.MinutesBefore] && !kept[r.MinutesBefore] && r.RemindAt.Equal(remindAt) {
+				kept[r.MinutesBefore] = true
+			} else {
+				stale = append(stale, r.ID)
+			}
+		}
+		var added []EventReminder
+		for _, r := range newEventReminders(e.StartsAt, minutes) {
+			if !kept[r.MinutesBefore] {
+				r.EventID = id
+				added = append(added, r)
+			}
+		}
+		if len(stale) > 0 {
+			if err := tx.Delete(&EventReminder{}, stale).Error; err != nil {
+				return err
+			}
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		return tx.Create(&added).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getEvent(db, id, userID)
+}
+
+// 予定とリマインダーを削除し、削除した予定を返す
+func deleteEvent(db *gorm.DB, id uint, userID uint) (*Event, error) {
+	var e Event
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Reminders").Where("id = ? AND user_id = ?", id, userID).First(&e).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("event_id = ?", e.ID).Delete(&EventReminder{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&e).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// 条件に合う予定の 1 ページと総件数を取得
+// more は次のページがあるかどうか
+func queryEvents(db *gorm.DB, userID uint, q ListQuery) (events []Event, total int64, more bool, err error) {
+	if err = q.filtered(db.Model(&Event{}).Scopes(liveEvents(db, userID))).Count(&total).Error; err != nil {
+		return nil, 0, false, err
+	}
+	if err = q.paged(db.Preload("Reminders").Scopes(liveEvents(db, userID))).Find(&events).Error; err != nil {
+		return nil, 0, false, err
+	}
+	if q.Limit > 0 && len(events) > q.Limit {
+		events, more = events[:q.Limit], true
+	}
+	return events, total, more, nil
+}
+
+// from から to までの間に重なる予定を取得（終了時刻のない予定は開始時刻で判定）
+// 予定の日時は UTC で保存しているため、比較する日時も UTC にそろえる（SQLite は文字列として比べる）
+func listEventsBetween(db *gorm.DB, userID uint, from, to time.Time) ([]Event, error) {
+	var events []Event
+	err := db.Scopes(liveEvents(db, userID)).
+		Where("starts_at < ? AND COALESCE(ends_at, starts_at) >= ?", to.UTC(), from.UTC()).
+		Order("starts_at ASC, id ASC").
+		Find(&events).Error
+	return events, err
+}
+
+// fromDate から toDate までの日付（YYYY-MM-DD）に実施期間が重なるインターンシップを取得
+func listInternshipsBetween(db *gorm.DB, userID uint, fromDate, toDate string) ([]Internship, error) {
+	var internships []Internship
+	err := db.Where("user_id = ? AND starts_on <> '' AND starts_on <= ?", userID, toDate).
+		Where("(CASE WHEN ends_on = '' THEN starts_on ELSE ends_on END) >= ?", fromDate).
+		Order("starts_on ASC, id ASC").
+		Find(&internships).Error
+	return internships, err
+}
+
+// ID を指定してインターンシップの企業名を取得（カレンダー用）
+func internshipCompaniesByIDs(db *gorm.DB, userID uint, ids []uint) (map[uint]string, error) {
+	var internships []Internship
+	if err := db.Select("id", "company").Where("user_id = ? AND id IN ?", userID, ids).Find(&internships).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(internships))
+	for _, in := range internships {
+		names[in.ID] = in.Company
+	}
+	return names, nil
+}
+
+// 送信時刻を過ぎたリマインダーを取得し、送信済みにする（同時に動く別のプロセスとは 1 件ずつ取り合う）
+// 送信済みにできたものだけを返す
+func claimDueReminders(db *gorm.DB, now time.Time, limit int) ([]EventReminder, error) {
+	var due []EventReminder
+	if err := db.Where("sent_at IS NULL AND remind_at <= ?", now).Order("remind_at ASC").Limit(limit).Find(&due).Error; err != nil {
+		return nil, err
+	}
+	claimed := due[:0]
+	for _, r := range due {
+		res := db.Model(&EventReminder{}).Where("id = ? AND sent_at IS NULL", r.ID).Update("sent_at", now)
+		if res.Error != nil {
+			return claimed, res.Error
+		}
+		if res.RowsAffected == 1 {
+			claimed = append(claimed, r)
+		}
+	}
+	return claimed, nil
+}
+
+// ユーザーの全予定（エクスポート用）
+func listEventsByUser(db *gorm.DB, userID uint) ([]Event, error) {
+	var events []Event
+	err := db.Preload("Reminders").Where("user_id = ?", userID).Order("starts_at ASC, id ASC").Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 選考ステージ（CompanyList.Stage / Internship.Stage に保存するキー）
+// 面接は interview_1, interview_2, ... のように回数を付ける
+// ユーザー独自のステージは custom_<SelectionStage.ID>
+const (
+	StageEntry           = "entry"
+	StageES              = "es"
+	StageWebTest         = "web_test"
+	StageGroupDiscussion = "group_discussion"
+	StageInterviewPrefix = "interview_"
+	StageFinal           = "final"
+	StageOffer           = "offer"
+	StageAccepted        = "accepted"
+	StageDeclined        = "declined"
+	StageRejected        = "rejected"
+
+	customStagePrefix = "custom_"
+	maxInterviewRound = 10
+)
+
+var (
+	// 存在しない・他人のステージが指定された
+	errUnknownStage = errors.New("unknown stage")
+	// 許可されていないステージの遷移
+	errInvalidStageTransition = errors.New("invalid stage transition")
+)
+
+// 組み込みステージの表示名と順序
+var builtinStages = []struct {
+	Key   string
+	Label string
+	Order int
+}{
+	{StageEntry, "エントリー", 10},
+	{StageES, "ES", 20},
+	{StageWebTest, "Webテスト", 30},
+	{StageGroupDiscussion, "グループディスカッション", 40},
+	// 面接は 100 + 回数
+	{StageFinal, "最終面接", 200},
+	{StageOffer, "内定", 300},
+	{StageAccepted, "内定承諾", 400},
+	{StageDeclined, "辞退", 400},
+	{StageRejected, "不合格", 400},
+}
+
+// isTerminalStage は選考が終わったステージかどうかを返します
+func isTerminalStage(key string) bool {
+	return key == StageAccepted || key == StageDeclined || key == StageRejected
+}
+
+// interviewRound は interview_N の N を返します（面接ステージでなければ 0）
+func interviewRound(key string) int {
+	if !strings.HasPrefix(key, StageInterviewPrefix) {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(key, StageInterviewPrefix))
+	if err != nil || n < 1 || n > maxInterviewRound {
+		return 0
+	}
+	return n
+}
+
+// interviewStage は N 次面接のキーを返します
+func interviewStage(round int) string {
+	return fmt.Sprintf("%s%d", StageInterviewPrefix, round)
+}
+
+// builtinStageOrder は組み込みステージの順序を返します（組み込みでなければ false）
+func builtinStageOrder(key string) (int, bool) {
+	if n := interviewRound(key); n > 0 {
+		return 100 + n, true
+	}
+	for _, s := range builtinStages {
+		if s.Key == key {
+			return s.Order, true
+		}
+	}
+	return 0, false
+}
+
+// builtinStageLabel は組み込みステージの表示名を返します
+func builtinStageLabel(key string) string {
+	if n := interviewRound(key); n > 0 {
+		return fmt.Sprintf("%d次面接", n)
+	}
+	for _, s := range builtinStages {
+		if s.Key == key {
+			return s.Label
+		}
+	}
+	return key
+}
+
+// Key はユーザー独自ステージのキーを返します
+func (s SelectionStage) Key() string {
+	return fmt.Sprintf("%s%d", customStagePrefix, s.ID)
+}
+
+// stageInfo はステージの表示名と並び順です
+// 独自ステージは After の直後（同じ位置に複数あれば作成順）に並ぶ
+type stageInfo struct {
+	Key      string
+	Label    string
+	Order    int
+	CustomID uint
+}
+
+// before は選考の進み具合で s が t より前かどうかを返します
+func (s stageInfo) before(t stageInfo) bool {
+	if s.Order != t.Order {
+		return s.Order < t.Order
+	}
+	return s.CustomID < t.CustomID
+}
+
+// lookupStage はステージのキーを検証し、表示名と順序を返します
+func lookupStage(db *gorm.DB, userID uint, key string) (stageInfo, error) {
+	if order, ok := builtinStageOrder(key); ok {
+		return stageInfo{Key: key, Label: builtinStageLabel(key), Order: order}, nil
+	}
+	if !strings.HasPrefix(key, customStagePrefix) {
+		return stageInfo{}, errUnknownStage
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(key, customStagePrefix), 10, 64)
+	if err != nil {
+		return stageInfo{}, errUnknownStage
+	}
+	cs, err := getSelectionStage(db, uint(id), userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return stageInfo{}, errUnknownStage
+		}
+		return stageInfo{}, err
+	}
+	order, _ := builtinStageOrder(cs.After)
+	return stageInfo{Key: key, Label: cs.Name, Order: order, CustomID: cs.ID}, nil
+}
+
+// validateStageTransition はステージの遷移が許されるか確認します
+//   - 未設定からはどのステージにも移れる
+//   - 選考中は先のステージへ進める（途中の省略は可、後戻りは不可）
+//   - 選考中はいつでも辞退・不合格にできる
+//   - 内定承諾は内定からのみ、承諾後は辞退のみ可能
+func validateStageTransition(db *gorm.DB, userID uint, from, to string) error {
+	if from == "" || from == to {
+		return nil
+	}
+	toInfo, err := lookupStage(db, userID, to)
+	if err != nil {
+		return err
+	}
+	switch {
+	case from == StageAccepted:
+		if to == StageDeclined {
+			return nil
+		}
+		return errInvalidStageTransition
+	case isTerminalStage(from):
+		return errInvalidStageTransition
+	case to == StageDeclined || to == StageRejected:
+		return nil
+	case to == StageAccepted:
+		if from == StageOffer {
+			return nil
+		}
+		return errInvalidStageTransition
+	}
+	fromInfo, err := lookupStage(db, userID, from)
+	if err != nil {
+		// 削除された独自ステージなどからは自由に移れる
+		if errors.Is(err, errUnknownStage) {
+			return nil
+		}
+		return err
+	}
+	if !fromInfo.before(toInfo) {
+		return errInvalidStageTransition
+	}
+	return nil
+}
+
+// resolveStage は入力されたステージ（なければ選考状況の文字列）から保存するステージと表示名を決めます
+func resolveStage(db *gorm.DB, userID uint, stage, selection string) (string, string, error) {
+	if stage == "" {
+		key := mapLegacySelection(selection)
+		return key, selection, nil
+	}
+	info, err := lookupStage(db, userID, stage)
+	if err != nil {
+		return "", "", err
+	}
+	if selection == "" {
+		selection = info.Label
+	}
+	return info.Key, selection, nil
+}
+
+var (
+	interviewDigitPattern  = regexp.MustCompile(`([0-9０-９]+)\s*(次|st|nd|rd|th)`)
+	interviewKanjiNumerals = map[string]int{"一": 1, "二": 2, "三": 3, "四": 4, "五": 5, "六": 6, "七": 7, "八": 8, "九": 9, "十": 10}
+)
+
+// mapLegacySelection は自由入力の選考状況（"1st Interview" / "二次面接" など）を
+// できる範囲でステージに変換します（判定できなければ空文字）
+func mapLegacySelection(selection string) string {
+	s := strings.ToLower(strings.TrimSpace(selection))
+	if s == "" {
+		return ""
+	}
+	// 日本語の語は部分一致、英字の語は単語の先頭から（word）または単語全体（token）で照合する
+	// 英字を部分一致にすると "yes" や "presentation" が ES に、"latest" がテストになってしまう
+	has := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(s, w) {
+				return true
+			}
+		}
+		return false
+	}
+	word := func(words ...string) bool {
+		for _, w := range words {
+			if containsLatinWord(s, w, false) {
+				return true
+			}
+		}
+		return false
+	}
+	token := func(words ...string) bool {
+		for _, w := range words {
+			if containsLatinWord(s, w, true) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("内定承諾", "承諾", "入社決定") || word("accept"):
+		return StageAccepted
+	case has("辞退") || word("declin", "withdr"):
+		return StageDeclined
+	case has("お祈り", "不合格", "落選", "不採用", "落ち") || word("reject", "fail"):
+		return StageRejected
+	case has("内定", "内々定") || word("offer"):
+		return StageOffer
+	case has("最終") || word("final"):
+		return StageFinal
+	case has("面接", "面談") || word("interview"):
+		return interviewStage(parseInterviewRound(s))
+	case has("グループディスカッション", "グルディス") || token("gd") || word("group discussion", "group_discussion"):
+		return StageGroupDiscussion
+	case has("webテスト", "適性検査", "玉手箱", "テスト") || word("web test", "webtest", "test") || token("spi"):
+		return StageWebTest
+	case has("エントリーシート", "書類") || token("es") || word("entry sheet"):
+		return StageES
+	case has("エントリー", "応募", "説明会") || word("entry"):
+		return StageEntry
+	}
+	return ""
+}
+
+// containsLatinWord は s（小文字）に英字の語 w が単語の先頭から現れるか調べます
+// 英数字以外（空白・記号・日本語）と文字列の端を単語の区切りとみなすため、"ES提出" や "spi3" も一致します
+// whole が true の場合は、語の直後も英字以外でなければ一致としません
+func containsLatinWord(s, w string, whole bool) bool {
+	isWordByte := func(b byte) bool { return b >= 'a' && b <= 'z' || b >= '0' && b <= '9' }
+	for i := 0; i+len(w) <= len(s); {
+		j := strings.Index(s[i:], w)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(w)
+		if (start == 0 || !isWordByte(s[start-1])) &&
+			(!whole || end == len(s) || !(s[end] >= 'a' && s[end] <= 'z')) {
+			return true
+		}
+		i = start + 1
+	}
+	return false
+}
+
+// parseInterviewRound は "2次面接" / "二次面接" / "2nd interview" から回数を読み取ります（不明なら 1）
+func parseInterviewRound(s string) int {
+	if m := interviewDigitPattern.FindStringSubmatch(s); m != nil {
+		digits := strings.Map(func(r rune) rune {
+			if r >= '０' && r <= '９' {
+				return r - '０' + '0'
+			}
+			return r
+		}, m[1])
+		if n, err := strconv.Atoi(digits); err == nil && n >= 1 && n <= maxInterviewRound {
+			return n
+		}
+	}
+	for kanji, n := range interviewKanjiNumerals {
+		if strings.Contains(s, kanji+"次") {
+			return n
+		}
+	}
+	return 1
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMapLegacySelection(t *testing.T) {
+	tests := []struct {
+		selection string
+		want      string
+	}{
+		{"", ""},
+		{"説明会予約", StageEntry},
+		{"Entry", StageEntry},
+		{"ES", StageES},
+		{"ES提出済み", StageES},
+		{"es submitted", StageES},
+		{"Entry Sheet", StageES},
+		{"書類選考中", StageES},
+		{"SPI", StageWebTest},
+		{"SPI3", StageWebTest},
+		{"Webテスト受検", StageWebTest},
+		{"web test", StageWebTest},
+		{"GD", StageGroupDiscussion},
+		{"GD通過", StageGroupDiscussion},
+		{"グループディスカッション", StageGroupDiscussion},
+		{"一次面接", interviewStage(1)},
+		{"二次面接", interviewStage(2)},
+		{"2nd Interview", interviewStage(2)},
+		{"最終面接", StageFinal},
+		{"Final interview", StageFinal},
+		{"内々定", StageOffer},
+		{"Offer", StageOffer},
+		{"内定承諾", StageAccepted},
+		{"accepted", StageAccepted},
+		{"辞退", StageDeclined},
+		{"withdrawn", StageDeclined},
+		{"お祈り", StageRejected},
+		{"Rejected", StageRejected},
+		// 英字の語の一部に含まれるだけでは一致しない
+		{"presentation", ""},
+		{"message", ""},
+		{"yes", ""},
+		{"budget", ""},
+		{"latest", ""},
+		{"contest", ""},
+		{"reoffer", ""},
+	}
+	for _, tt := range tests {
+		if got := mapLegacySelection(tt.selection); got != tt.want {
+			t.Errorf("mapLegacySelection(%q) = %q, want %q", tt.selection, got, tt.want)
+		}
+	}
+}
+
+func TestValidateStageTransition(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db)
+	custom := &SelectionStage{UserID: user.ID, Name: "リクルーター面談", After: StageES}
+	if err := createSelectionStage(db, custom); err != nil {
+		t.Fatalf("createSelectionStage: %v", err)
+	}
+
+	tests := []struct {
+		from, to string
+		want     error
+	}{
+		{"", StageOffer, nil},
+		{StageEntry, StageEntry, nil},
+		{StageEntry, StageES, nil},
+		{StageES, interviewStage(2), nil},
+		{interviewStage(2), interviewStage(1), errInvalidStageTransition},
+		{StageFinal, StageWebTest, errInvalidStageTransition},
+		{StageWebTest, StageRejected, nil},
+		{interviewStage(1), StageDeclined, nil},
+		{StageFinal, StageAccepted, errInvalidStageTransition},
+		{StageOffer, StageAccepted, nil},
+		{StageAccepted, StageDeclined, nil},
+		{StageAccepted, StageOffer, errInvalidStageTransition},
+		{StageRejected, StageEntry, errInvalidStageTransition},
+		{StageDeclined, StageOffer, errInvalidStageTransition},
+		{StageES, custom.Key(), nil},
+		{custom.Key(), StageWebTest, nil},
+		{StageWebTest, custom.Key(), errInvalidStageTransition},
+		{"custom_9999", StageEntry, nil},
+		{StageEntry, "custom_9999", errUnknownStage},
+		{StageEntry, "unknown", errUnknownStage},
+	}
+	for _, tt := range tests {
+		if err := validateStageTransition(db, user.ID, tt.from, tt.to); !errors.Is(err, tt.want) {
+			t.Errorf("validateStageTransition(%q, %q) = %v, want %v", tt.from, tt.to, err, tt.want)
+		}
+	}
+}
+
+func TestStagePatchApply(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db)
+
+	tests := []struct {
+		name    string
+		patch   stagePatch
+		want    map[string]interface{}
+		wantErr error
+	}{
+		{name: "no stage or selection", patch: stagePatch{}, want: map[string]interface{}{}},
+		{
+			name:  "guessed stage is a forward move",
+			patch: stagePatch{Selection: "内定", HasSelection: true},
+			want:  map[string]interface{}{"stage": StageOffer, "selection": "内定"},
+		},
+		{
+			name:  "guessed stage is a backward move",
+			patch: stagePatch{Selection: "一次面接の振り返り", HasSelection: true},
+			want:  map[string]interface{}{"stage": StageFinal, "selection": "一次面接の振り返り"},
+		},
+		{
+			name:  "selection without a stage",
+			patch: stagePatch{Selection: "", HasSelection: true},
+			want:  map[string]interface{}{"stage": StageFinal, "selection": ""},
+		},
+		{
+			name:  "stage with an empty selection",
+			patch: stagePatch{Stage: StageOffer, HasStage: true, Selection: "", HasSelection: true},
+			want:  map[string]interface{}{"stage": StageOffer, "selection": ""},
+		},
+		{
+			name:  "stage without a selection",
+			patch: stagePatch{Stage: StageOffer, HasStage: true},
+			want:  map[string]interface{}{"stage": StageOffer, "selection": builtinStageLabel(StageOffer)},
+		},
+		{name: "explicit backward stage", patch: stagePatch{Stage: StageES, HasStage: true}, wantErr: errInvalidStageTransition},
+		{name: "empty stage", patch: stagePatch{Stage: "", HasStage: true}, wantErr: errUnknownStage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := map[string]interface{}{}
+			err := tt.patch.apply(db, user.ID, StageFinal, updates)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("apply() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(updates, tt.want) {
+				t.Errorf("updates = %v, want %v", updates, tt.want)
+			}
+		})
+	}
+}